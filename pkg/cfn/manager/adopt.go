@@ -0,0 +1,529 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cfntypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+
+	"github.com/weaveworks/eksctl/pkg/apis/eksctl.io/v1alpha5"
+	"github.com/weaveworks/eksctl/pkg/cfn/builder"
+	"github.com/weaveworks/eksctl/pkg/utils/tasks"
+)
+
+// ownedTagKey is the tag EKS/kube-controller-manager sets on
+// cluster-autoscaler managed ASGs and the kubelet sets on nodes, marking a
+// resource as belonging to (but not necessarily created by eksctl for) the
+// named cluster.
+const ownedTagKey = "kubernetes.io/cluster/%s"
+
+// eksctlNodeGroupNameTag is the tag eksctl stamps on every nodegroup stack
+// it creates. Its absence on an otherwise-owned resource is what makes that
+// resource "unowned" from eksctl's perspective.
+const eksctlNodeGroupNameTag = "eksctl.cluster.k8s.io/v1alpha1/nodegroup-name"
+
+// UnownedNodeGroupKind distinguishes the two kinds of resource
+// ListUnownedNodeGroups can discover.
+type UnownedNodeGroupKind string
+
+const (
+	// UnownedNodeGroupKindManaged is an EKS managed nodegroup with no
+	// corresponding eksctl-owned CloudFormation stack.
+	UnownedNodeGroupKindManaged UnownedNodeGroupKind = "Managed"
+	// UnownedNodeGroupKindUnmanaged is a standalone ASG tagged for the
+	// cluster with no corresponding eksctl-owned CloudFormation stack.
+	UnownedNodeGroupKindUnmanaged UnownedNodeGroupKind = "Unmanaged"
+)
+
+// UnownedNodeGroup is an EKS-side nodegroup or standalone ASG that belongs
+// to the cluster but has no matching eksctl nodegroup stack, discovered by
+// ListUnownedNodeGroups.
+type UnownedNodeGroup struct {
+	Name string
+	Kind UnownedNodeGroupKind
+	// ASGName is set for both kinds: the EKS-managed ASG backing a managed
+	// nodegroup, or the standalone ASG itself.
+	ASGName string
+	// ManagedNodeGroup is set only when Kind is UnownedNodeGroupKindManaged.
+	ManagedNodeGroup *ekstypes.Nodegroup
+}
+
+// AdoptionReport summarises the outcome of adopting a single
+// UnownedNodeGroup, for display by `eksctl adopt nodegroups`.
+type AdoptionReport struct {
+	Name       string
+	Kind       UnownedNodeGroupKind
+	StackName  string
+	Drifted    bool
+	DriftNotes []string
+	Err        error
+}
+
+// ListUnownedNodeGroups reconciles EKS-side managed nodegroups and
+// standalone cluster-tagged ASGs against the stacks ListNodeGroupStacks
+// returns, and returns the ones with no matching eksctl nodegroup stack.
+func (c *StackCollection) ListUnownedNodeGroups(ctx context.Context) ([]UnownedNodeGroup, error) {
+	ownedStacks, err := c.ListNodeGroupStacks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing nodegroup stacks: %w", err)
+	}
+	ownedNames := make(map[string]struct{}, len(ownedStacks))
+	for _, s := range ownedStacks {
+		ownedNames[c.GetNodeGroupName(s)] = struct{}{}
+	}
+
+	managed, err := c.listUnownedManagedNodeGroups(ctx, ownedNames)
+	if err != nil {
+		return nil, err
+	}
+	unmanaged, err := c.listUnownedASGs(ctx, ownedNames)
+	if err != nil {
+		return nil, err
+	}
+	return append(managed, unmanaged...), nil
+}
+
+// listUnownedManagedNodeGroups lists EKS managed nodegroups for the cluster
+// via the EKS ListNodegroups API and cross-references them against
+// ownedNames; each remaining nodegroup is described to recover its backing
+// ASG name.
+func (c *StackCollection) listUnownedManagedNodeGroups(ctx context.Context, ownedNames map[string]struct{}) ([]UnownedNodeGroup, error) {
+	clusterName := c.spec.Metadata.Name
+
+	var unowned []UnownedNodeGroup
+	paginator := eks.NewListNodegroupsPaginator(c.eksAPI, &eks.ListNodegroupsInput{
+		ClusterName: &clusterName,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing EKS managed nodegroups for cluster %q: %w", clusterName, err)
+		}
+		for _, name := range page.Nodegroups {
+			if _, owned := ownedNames[name]; owned {
+				continue
+			}
+			nodeGroupName := name
+			describeOut, err := c.eksAPI.DescribeNodegroup(ctx, &eks.DescribeNodegroupInput{
+				ClusterName:   &clusterName,
+				NodegroupName: &nodeGroupName,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("describing EKS managed nodegroup %q: %w", nodeGroupName, err)
+			}
+
+			var asgName string
+			if describeOut.Nodegroup.Resources != nil && len(describeOut.Nodegroup.Resources.AutoScalingGroups) > 0 {
+				asgName = aws.ToString(describeOut.Nodegroup.Resources.AutoScalingGroups[0].Name)
+			}
+
+			unowned = append(unowned, UnownedNodeGroup{
+				Name:             nodeGroupName,
+				Kind:             UnownedNodeGroupKindManaged,
+				ASGName:          asgName,
+				ManagedNodeGroup: describeOut.Nodegroup,
+			})
+		}
+	}
+	return unowned, nil
+}
+
+// listUnownedASGs lists standalone ASGs tagged
+// fmt.Sprintf(ownedTagKey, clusterName)=owned with no eksctlNodeGroupNameTag
+// tag, or whose eksctlNodeGroupNameTag tag doesn't match ownedNames.
+func (c *StackCollection) listUnownedASGs(ctx context.Context, ownedNames map[string]struct{}) ([]UnownedNodeGroup, error) {
+	ownedTag := fmt.Sprintf(ownedTagKey, c.spec.Metadata.Name)
+
+	var unowned []UnownedNodeGroup
+	paginator := autoscaling.NewDescribeAutoScalingGroupsPaginator(c.asgAPI, &autoscaling.DescribeAutoScalingGroupsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing autoscaling groups: %w", err)
+		}
+		for _, asg := range page.AutoScalingGroups {
+			isOwnedByCluster, nodeGroupNameTag := classifyASGOwnershipTags(asg.Tags, ownedTag)
+			if !isOwnedByCluster {
+				continue
+			}
+			if _, owned := ownedNames[nodeGroupNameTag]; owned {
+				continue
+			}
+
+			asgName := aws.ToString(asg.AutoScalingGroupName)
+			name := nodeGroupNameTag
+			if name == "" {
+				name = asgName
+			}
+			unowned = append(unowned, UnownedNodeGroup{
+				Name:    name,
+				Kind:    UnownedNodeGroupKindUnmanaged,
+				ASGName: asgName,
+			})
+		}
+	}
+	return unowned, nil
+}
+
+// classifyASGOwnershipTags reports whether tags mark an ASG as owned by the
+// cluster (ownedTag=owned) and, if present, the nodegroup name recorded in
+// its eksctlNodeGroupNameTag tag. It is a pure function so the tag-matching
+// logic can be unit tested without talking to AWS.
+func classifyASGOwnershipTags(tags []asgtypes.TagDescription, ownedTag string) (owned bool, nodeGroupName string) {
+	for _, tag := range tags {
+		if tag.Key == nil || tag.Value == nil {
+			continue
+		}
+		switch *tag.Key {
+		case ownedTag:
+			owned = *tag.Value == "owned"
+		case eksctlNodeGroupNameTag:
+			nodeGroupName = *tag.Value
+		}
+	}
+	return owned, nodeGroupName
+}
+
+// NewTasksToAdoptNodeGroups builds a task tree that, for each unowned
+// group, synthesizes a minimal resource set from the live launch template
+// and ASG configuration and creates a CFN stack that imports the existing
+// resources, so no instances churn. It also returns one *AdoptionReport
+// per group, populated as the corresponding task runs, so a CLI command
+// can render a summary once the task tree completes.
+func (c *StackCollection) NewTasksToAdoptNodeGroups(ctx context.Context, groups []UnownedNodeGroup) (*tasks.TaskTree, []*AdoptionReport, error) {
+	taskTree := &tasks.TaskTree{Parallel: true}
+	reports := make([]*AdoptionReport, len(groups))
+	for i, group := range groups {
+		report := &AdoptionReport{Name: group.Name, Kind: group.Kind}
+		reports[i] = report
+		taskTree.Append(&adoptNodeGroupTask{
+			info:         fmt.Sprintf("adopt nodegroup %q", group.Name),
+			ctx:          ctx,
+			stackManager: c,
+			group:        group,
+			report:       report,
+		})
+	}
+	return taskTree, reports, nil
+}
+
+type adoptNodeGroupTask struct {
+	info         string
+	ctx          context.Context
+	stackManager *StackCollection
+	group        UnownedNodeGroup
+	report       *AdoptionReport
+}
+
+func (t *adoptNodeGroupTask) Describe() string { return t.info }
+
+func (t *adoptNodeGroupTask) Do(errorCh chan error) error {
+	defer close(errorCh)
+	if err := t.stackManager.adoptNodeGroup(t.ctx, t.group, t.report); err != nil {
+		t.report.Err = err
+		return err
+	}
+	return nil
+}
+
+// adoptNodeGroup synthesizes a resource set for group from its live
+// configuration and imports the live resources it describes into a new
+// stack via a CFN IMPORT change-set, so CloudFormation takes ownership of
+// the existing ASG and launch template without replacing (or colliding
+// with) them. Any divergence between the live ASG state and what the
+// synthesized resource set expects is recorded on report as drift.
+func (c *StackCollection) adoptNodeGroup(ctx context.Context, group UnownedNodeGroup, report *AdoptionReport) error {
+	report.StackName = fmt.Sprintf("eksctl-%s-nodegroup-%s", c.spec.Metadata.Name, group.Name)
+
+	resourceSet, driftNotes, err := c.synthesizeResourceSetForImport(ctx, group)
+	if err != nil {
+		return err
+	}
+	report.DriftNotes = driftNotes
+	report.Drifted = len(driftNotes) > 0
+
+	templateBody, err := resourceSet.RenderJSON()
+	if err != nil {
+		return fmt.Errorf("rendering import template for %q: %w", group.Name, err)
+	}
+
+	resourcesToImport, err := c.resourcesToImportFromTemplate(ctx, templateBody, group)
+	if err != nil {
+		return fmt.Errorf("resolving physical resources to import for %q: %w", group.Name, err)
+	}
+
+	// An IMPORT change-set for a brand-new stack requires every resource in
+	// its template to be listed in ResourcesToImport - CFN can't create new
+	// resources in the same operation that imports existing ones. So the
+	// template submitted here is pared down to just the resources we've
+	// resolved live physical IDs for (the ASG and launch template); anything
+	// else the synthesized resource set produced (e.g. an IAM role) is left
+	// out rather than sent to an import change-set that CFN would reject.
+	importLogicalIDs := make(map[string]struct{}, len(resourcesToImport))
+	for _, r := range resourcesToImport {
+		importLogicalIDs[*r.LogicalResourceId] = struct{}{}
+	}
+	importTemplateBody, err := filterTemplateToResources(templateBody, importLogicalIDs)
+	if err != nil {
+		return fmt.Errorf("preparing import template for %q: %w", group.Name, err)
+	}
+
+	if err := c.importStack(ctx, report.StackName, importTemplateBody, resourcesToImport, map[string]string{
+		eksctlNodeGroupNameTag: group.Name,
+	}); err != nil {
+		return fmt.Errorf("creating adoption stack for %q: %w", group.Name, err)
+	}
+	return nil
+}
+
+// resourcesToImportFromTemplate walks the rendered CFN template for group's
+// synthesized resource set and, for every resource type it knows how to
+// identify, resolves the live physical resource behind it so CreateChangeSet
+// can import it into the stack instead of creating (and colliding with, or
+// replacing) it. Resource types it doesn't recognise are left out of
+// ResourcesToImport entirely - filterTemplateToResources then drops them
+// from the template submitted for import too, since CFN's IMPORT change-set
+// type can't create them alongside the import.
+func (c *StackCollection) resourcesToImportFromTemplate(ctx context.Context, templateBody []byte, group UnownedNodeGroup) ([]cfntypes.ResourceToImport, error) {
+	logicalResourceTypes, err := templateResourceTypes(templateBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var asg *asgtypes.AutoScalingGroup
+	if group.ASGName != "" {
+		described, err := c.describeAutoScalingGroup(ctx, group.ASGName)
+		if err != nil {
+			return nil, fmt.Errorf("describing autoscaling group %q: %w", group.ASGName, err)
+		}
+		asg = described
+	}
+
+	var launchTemplateName string
+	if asg != nil && asg.LaunchTemplate != nil && asg.LaunchTemplate.LaunchTemplateName != nil {
+		launchTemplateName = *asg.LaunchTemplate.LaunchTemplateName
+	}
+
+	return buildResourcesToImport(logicalResourceTypes, group.ASGName, launchTemplateName)
+}
+
+// templateResourceTypes parses a rendered CFN template and returns the
+// resource type recorded under each logical resource ID. It is a pure
+// function so the template-parsing logic can be unit tested without
+// rendering a real resource set.
+func templateResourceTypes(templateBody []byte) (map[string]string, error) {
+	var template struct {
+		Resources map[string]struct {
+			Type string `json:"Type"`
+		} `json:"Resources"`
+	}
+	if err := json.Unmarshal(templateBody, &template); err != nil {
+		return nil, fmt.Errorf("parsing rendered import template: %w", err)
+	}
+
+	types := make(map[string]string, len(template.Resources))
+	for logicalID, resource := range template.Resources {
+		types[logicalID] = resource.Type
+	}
+	return types, nil
+}
+
+// buildResourcesToImport matches each logical resource CFN expects to find
+// in logicalResourceTypes against the live physical resource it should be
+// imported from - asgName for the ASG, launchTemplateName for the launch
+// template - and errors if a resource type it knows how to import has no
+// live counterpart to import from. Resource types it doesn't recognise are
+// left out of the result entirely; filterTemplateToResources then drops
+// them from the template submitted for import too, since an IMPORT
+// change-set can't create new resources alongside the ones it imports. It
+// is a pure function so the identifier-matching logic can be unit tested
+// without talking to AWS.
+func buildResourcesToImport(logicalResourceTypes map[string]string, asgName, launchTemplateName string) ([]cfntypes.ResourceToImport, error) {
+	var resourcesToImport []cfntypes.ResourceToImport
+	for logicalID, resourceType := range logicalResourceTypes {
+		switch resourceType {
+		case "AWS::AutoScaling::AutoScalingGroup":
+			if asgName == "" {
+				return nil, fmt.Errorf("no live autoscaling group known to import as %q", logicalID)
+			}
+			resourcesToImport = append(resourcesToImport, cfntypes.ResourceToImport{
+				ResourceType:       aws.String(resourceType),
+				LogicalResourceId:  aws.String(logicalID),
+				ResourceIdentifier: map[string]string{"AutoScalingGroupName": asgName},
+			})
+		case "AWS::EC2::LaunchTemplate":
+			if launchTemplateName == "" {
+				return nil, fmt.Errorf("no live launch template known to import as %q", logicalID)
+			}
+			resourcesToImport = append(resourcesToImport, cfntypes.ResourceToImport{
+				ResourceType:       aws.String(resourceType),
+				LogicalResourceId:  aws.String(logicalID),
+				ResourceIdentifier: map[string]string{"LaunchTemplateName": launchTemplateName},
+			})
+		}
+	}
+	if len(resourcesToImport) == 0 {
+		return nil, fmt.Errorf("no importable resources found in synthesized template")
+	}
+	return resourcesToImport, nil
+}
+
+// filterTemplateToResources returns a copy of templateBody with its
+// Resources section pared down to only the given logical IDs. A CFN IMPORT
+// change-set requires every resource in the template to appear in
+// ResourcesToImport, so the template submitted for import must not contain
+// resources - like a synthesized IAM role - that have no live physical
+// counterpart to import. It is a pure function so the template-trimming
+// logic can be unit tested without rendering a real resource set.
+func filterTemplateToResources(templateBody []byte, logicalIDs map[string]struct{}) ([]byte, error) {
+	var template map[string]json.RawMessage
+	if err := json.Unmarshal(templateBody, &template); err != nil {
+		return nil, fmt.Errorf("parsing rendered import template: %w", err)
+	}
+
+	var resources map[string]json.RawMessage
+	if err := json.Unmarshal(template["Resources"], &resources); err != nil {
+		return nil, fmt.Errorf("parsing template resources: %w", err)
+	}
+
+	filtered := make(map[string]json.RawMessage, len(logicalIDs))
+	for logicalID := range logicalIDs {
+		if raw, ok := resources[logicalID]; ok {
+			filtered[logicalID] = raw
+		}
+	}
+	filteredResources, err := json.Marshal(filtered)
+	if err != nil {
+		return nil, fmt.Errorf("re-encoding filtered resources: %w", err)
+	}
+	template["Resources"] = filteredResources
+
+	out, err := json.Marshal(template)
+	if err != nil {
+		return nil, fmt.Errorf("re-encoding import template: %w", err)
+	}
+	return out, nil
+}
+
+// importStack creates stackName via a CFN IMPORT change-set rather than a
+// plain CreateStack, so resourcesToImport's physical resources are taken
+// under CloudFormation's management without being recreated or churned.
+func (c *StackCollection) importStack(ctx context.Context, stackName string, templateBody []byte, resourcesToImport []cfntypes.ResourceToImport, tags map[string]string) error {
+	changeSetName := c.MakeChangeSetName(fmt.Sprintf("adopt-%s", stackName))
+
+	if _, err := c.cloudformationAPI.CreateChangeSet(ctx, &cloudformation.CreateChangeSetInput{
+		StackName:         aws.String(stackName),
+		ChangeSetName:     aws.String(changeSetName),
+		ChangeSetType:     cfntypes.ChangeSetTypeImport,
+		TemplateBody:      aws.String(string(templateBody)),
+		ResourcesToImport: resourcesToImport,
+		Tags:              tagsFromMap(tags),
+	}); err != nil {
+		return fmt.Errorf("creating import change set for stack %q: %w", stackName, err)
+	}
+
+	describeInput := &cloudformation.DescribeChangeSetInput{
+		StackName:     aws.String(stackName),
+		ChangeSetName: aws.String(changeSetName),
+	}
+	if err := cloudformation.NewChangeSetCreateCompleteWaiter(c.cloudformationAPI).Wait(ctx, describeInput, changeSetWaitTimeout); err != nil {
+		return fmt.Errorf("waiting for import change set on stack %q: %w", stackName, err)
+	}
+
+	if _, err := c.cloudformationAPI.ExecuteChangeSet(ctx, &cloudformation.ExecuteChangeSetInput{
+		StackName:     aws.String(stackName),
+		ChangeSetName: aws.String(changeSetName),
+	}); err != nil {
+		return fmt.Errorf("executing import change set on stack %q: %w", stackName, err)
+	}
+	return nil
+}
+
+// synthesizeResourceSetForImport builds the minimal resource set the
+// import change-set needs to import group's existing AWS resources, based
+// on its kind.
+func (c *StackCollection) synthesizeResourceSetForImport(ctx context.Context, group UnownedNodeGroup) (builder.ResourceSetReader, []string, error) {
+	switch group.Kind {
+	case UnownedNodeGroupKindManaged:
+		return c.synthesizeManagedResourceSet(ctx, group)
+	case UnownedNodeGroupKindUnmanaged:
+		return c.synthesizeUnmanagedResourceSet(ctx, group)
+	default:
+		return nil, nil, fmt.Errorf("unknown unowned nodegroup kind %q", group.Kind)
+	}
+}
+
+// synthesizeManagedResourceSet rebuilds the v1alpha5.ManagedNodeGroup spec
+// a fresh `eksctl create nodegroup` would have produced for group, from the
+// live EKS nodegroup description, and flags drift between the EKS-side
+// scaling config and the ASG's actual desired capacity.
+func (c *StackCollection) synthesizeManagedResourceSet(ctx context.Context, group UnownedNodeGroup) (builder.ResourceSetReader, []string, error) {
+	ng := group.ManagedNodeGroup
+	if ng == nil || ng.ScalingConfig == nil {
+		return nil, nil, fmt.Errorf("no managed nodegroup scaling config for %q", group.Name)
+	}
+
+	managedNG := &v1alpha5.ManagedNodeGroup{
+		NodeGroupBase: &v1alpha5.NodeGroupBase{
+			Name: group.Name,
+			ScalingConfig: &v1alpha5.ScalingConfig{
+				MinSize:         aws.Int(int(aws.ToInt32(ng.ScalingConfig.MinSize))),
+				MaxSize:         aws.Int(int(aws.ToInt32(ng.ScalingConfig.MaxSize))),
+				DesiredCapacity: aws.Int(int(aws.ToInt32(ng.ScalingConfig.DesiredSize))),
+			},
+		},
+	}
+
+	var driftNotes []string
+	if group.ASGName != "" {
+		asg, err := c.describeAutoScalingGroup(ctx, group.ASGName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("describing backing autoscaling group %q: %w", group.ASGName, err)
+		}
+		if desired := aws.ToInt32(ng.ScalingConfig.DesiredSize); asg.DesiredCapacity != nil && *asg.DesiredCapacity != desired {
+			driftNotes = append(driftNotes, fmt.Sprintf(
+				"ASG %q desired capacity %d differs from EKS nodegroup %q's configured desired size %d",
+				group.ASGName, *asg.DesiredCapacity, group.Name, desired))
+		}
+	}
+
+	return builder.NewManagedNodeGroupResourceSet(c.ec2API, c.iamAPI, c.spec, managedNG), driftNotes, nil
+}
+
+// synthesizeUnmanagedResourceSet rebuilds the v1alpha5.NodeGroup spec a
+// fresh `eksctl create nodegroup` would have produced for group, from the
+// live ASG configuration, and flags drift between the ASG's configured
+// desired capacity and its actual running instance count.
+func (c *StackCollection) synthesizeUnmanagedResourceSet(ctx context.Context, group UnownedNodeGroup) (builder.ResourceSetReader, []string, error) {
+	asg, err := c.describeAutoScalingGroup(ctx, group.ASGName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("describing autoscaling group %q: %w", group.ASGName, err)
+	}
+
+	ng := &v1alpha5.NodeGroup{
+		NodeGroupBase: &v1alpha5.NodeGroupBase{
+			Name: group.Name,
+			ScalingConfig: &v1alpha5.ScalingConfig{
+				MinSize:         aws.Int(int(aws.ToInt32(asg.MinSize))),
+				MaxSize:         aws.Int(int(aws.ToInt32(asg.MaxSize))),
+				DesiredCapacity: aws.Int(int(aws.ToInt32(asg.DesiredCapacity))),
+			},
+		},
+	}
+
+	var driftNotes []string
+	if asg.DesiredCapacity != nil && int(*asg.DesiredCapacity) != len(asg.Instances) {
+		driftNotes = append(driftNotes, fmt.Sprintf(
+			"ASG %q desired capacity %d does not match %d running instance(s)",
+			group.ASGName, *asg.DesiredCapacity, len(asg.Instances)))
+	}
+
+	return builder.NewNodeGroupResourceSet(c.ec2API, c.iamAPI, c.spec, ng), driftNotes, nil
+}
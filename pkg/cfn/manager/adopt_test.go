@@ -0,0 +1,168 @@
+package manager
+
+import (
+	"testing"
+
+	asgtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	cfntypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func tagDescription(key, value string) asgtypes.TagDescription {
+	return asgtypes.TagDescription{Key: &key, Value: &value}
+}
+
+func TestClassifyASGOwnershipTags(t *testing.T) {
+	const ownedTag = "kubernetes.io/cluster/my-cluster"
+
+	tests := []struct {
+		name          string
+		tags          []asgtypes.TagDescription
+		wantOwned     bool
+		wantNodeGroup string
+	}{
+		{
+			name:          "owned with no eksctl nodegroup tag",
+			tags:          []asgtypes.TagDescription{tagDescription(ownedTag, "owned")},
+			wantOwned:     true,
+			wantNodeGroup: "",
+		},
+		{
+			name: "owned and already has an eksctl nodegroup tag",
+			tags: []asgtypes.TagDescription{
+				tagDescription(ownedTag, "owned"),
+				tagDescription(eksctlNodeGroupNameTag, "ng-1"),
+			},
+			wantOwned:     true,
+			wantNodeGroup: "ng-1",
+		},
+		{
+			name:      "not tagged as owned by this cluster",
+			tags:      []asgtypes.TagDescription{tagDescription("kubernetes.io/cluster/other-cluster", "owned")},
+			wantOwned: false,
+		},
+		{
+			name:      "owned tag present but not set to owned",
+			tags:      []asgtypes.TagDescription{tagDescription(ownedTag, "shared")},
+			wantOwned: false,
+		},
+		{
+			name:      "no tags at all",
+			tags:      nil,
+			wantOwned: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owned, nodeGroup := classifyASGOwnershipTags(tt.tags, ownedTag)
+			if owned != tt.wantOwned {
+				t.Errorf("owned = %v, want %v", owned, tt.wantOwned)
+			}
+			if nodeGroup != tt.wantNodeGroup {
+				t.Errorf("nodeGroup = %q, want %q", nodeGroup, tt.wantNodeGroup)
+			}
+		})
+	}
+}
+
+func TestTemplateResourceTypes(t *testing.T) {
+	template := []byte(`{
+		"Resources": {
+			"NodeGroup": {"Type": "AWS::AutoScaling::AutoScalingGroup"},
+			"LaunchTemplate": {"Type": "AWS::EC2::LaunchTemplate"},
+			"NodeInstanceRole": {"Type": "AWS::IAM::Role"}
+		}
+	}`)
+
+	got, err := templateResourceTypes(template)
+	if err != nil {
+		t.Fatalf("templateResourceTypes() error = %v", err)
+	}
+	want := map[string]string{
+		"NodeGroup":        "AWS::AutoScaling::AutoScalingGroup",
+		"LaunchTemplate":   "AWS::EC2::LaunchTemplate",
+		"NodeInstanceRole": "AWS::IAM::Role",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("templateResourceTypes() = %v, want %v", got, want)
+	}
+	for logicalID, resourceType := range want {
+		if got[logicalID] != resourceType {
+			t.Errorf("got[%q] = %q, want %q", logicalID, got[logicalID], resourceType)
+		}
+	}
+}
+
+func TestBuildResourcesToImport(t *testing.T) {
+	logicalResourceTypes := map[string]string{
+		"NodeGroup":        "AWS::AutoScaling::AutoScalingGroup",
+		"LaunchTemplate":   "AWS::EC2::LaunchTemplate",
+		"NodeInstanceRole": "AWS::IAM::Role",
+	}
+
+	got, err := buildResourcesToImport(logicalResourceTypes, "my-asg", "my-lt")
+	if err != nil {
+		t.Fatalf("buildResourcesToImport() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("len(got) = %d, want 2 (the IAM role isn't imported)", len(got))
+	}
+
+	byLogicalID := make(map[string]cfntypes.ResourceToImport, len(got))
+	for _, r := range got {
+		byLogicalID[*r.LogicalResourceId] = r
+	}
+	if byLogicalID["NodeGroup"].ResourceIdentifier["AutoScalingGroupName"] != "my-asg" {
+		t.Errorf("NodeGroup ResourceIdentifier = %v, want AutoScalingGroupName=my-asg", byLogicalID["NodeGroup"].ResourceIdentifier)
+	}
+	if byLogicalID["LaunchTemplate"].ResourceIdentifier["LaunchTemplateName"] != "my-lt" {
+		t.Errorf("LaunchTemplate ResourceIdentifier = %v, want LaunchTemplateName=my-lt", byLogicalID["LaunchTemplate"].ResourceIdentifier)
+	}
+}
+
+func TestBuildResourcesToImportMissingLiveASG(t *testing.T) {
+	logicalResourceTypes := map[string]string{"NodeGroup": "AWS::AutoScaling::AutoScalingGroup"}
+	if _, err := buildResourcesToImport(logicalResourceTypes, "", "my-lt"); err == nil {
+		t.Fatal("expected an error when no live autoscaling group name is known")
+	}
+}
+
+func TestBuildResourcesToImportMissingLiveLaunchTemplate(t *testing.T) {
+	logicalResourceTypes := map[string]string{"LaunchTemplate": "AWS::EC2::LaunchTemplate"}
+	if _, err := buildResourcesToImport(logicalResourceTypes, "my-asg", ""); err == nil {
+		t.Fatal("expected an error when no live launch template name is known")
+	}
+}
+
+func TestBuildResourcesToImportNoImportableResources(t *testing.T) {
+	logicalResourceTypes := map[string]string{"NodeInstanceRole": "AWS::IAM::Role"}
+	if _, err := buildResourcesToImport(logicalResourceTypes, "my-asg", "my-lt"); err == nil {
+		t.Fatal("expected an error when nothing in the template is importable")
+	}
+}
+
+func TestFilterTemplateToResources(t *testing.T) {
+	template := []byte(`{
+		"Resources": {
+			"NodeGroup": {"Type": "AWS::AutoScaling::AutoScalingGroup", "Properties": {"MinSize": "1"}},
+			"LaunchTemplate": {"Type": "AWS::EC2::LaunchTemplate"},
+			"NodeInstanceRole": {"Type": "AWS::IAM::Role"}
+		}
+	}`)
+
+	got, err := filterTemplateToResources(template, map[string]struct{}{"NodeGroup": {}, "LaunchTemplate": {}})
+	if err != nil {
+		t.Fatalf("filterTemplateToResources() error = %v", err)
+	}
+
+	types, err := templateResourceTypes(got)
+	if err != nil {
+		t.Fatalf("templateResourceTypes(filtered) error = %v", err)
+	}
+	if len(types) != 2 {
+		t.Fatalf("templateResourceTypes(filtered) = %v, want exactly NodeGroup and LaunchTemplate", types)
+	}
+	if _, ok := types["NodeInstanceRole"]; ok {
+		t.Error("filterTemplateToResources kept NodeInstanceRole, want it dropped")
+	}
+}
@@ -0,0 +1,645 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cfntypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+
+	"github.com/weaveworks/eksctl/pkg/utils/tasks"
+)
+
+// DriftRemediation classifies how a drifted resource can be brought back
+// into sync with its stack.
+type DriftRemediation string
+
+const (
+	// DriftRemediationReimport covers drift that can be folded back into
+	// the stack as-is, e.g. security group rules or route table entries an
+	// operator added out of band.
+	DriftRemediationReimport DriftRemediation = "ReimportSafe"
+	// DriftRemediationRequiresReplacement covers drift that can only be
+	// fixed by replacing the resource, e.g. a launch template edited in
+	// the console. It always requires explicit user approval.
+	DriftRemediationRequiresReplacement DriftRemediation = "RequiresReplacement"
+	// DriftRemediationAcceptLiveState covers drift that isn't worth
+	// reverting, e.g. tags an operator added. Remediation patches the CFN
+	// template to match what's live instead of touching the resource.
+	DriftRemediationAcceptLiveState DriftRemediation = "AcceptLiveState"
+)
+
+// driftDetectionPollInterval is how often describeStackResourceDrifts polls
+// DescribeStackDriftDetectionStatus while detection is in progress.
+const driftDetectionPollInterval = 5 * time.Second
+
+// driftDetectionTimeout bounds how long describeStackResourceDrifts waits
+// for CFN to finish detecting drift on a single stack.
+const driftDetectionTimeout = 5 * time.Minute
+
+// changeSetWaitTimeout bounds how long applyDriftRemediation waits for a
+// replacement change-set to finish creating before executing it.
+const changeSetWaitTimeout = 5 * time.Minute
+
+// replacementSensitiveProperties lists, per resource type, the property
+// paths that can't be reconciled in place - changing them forces CFN to
+// replace the resource, so drift touching them must go through the
+// RequiresReplacement path rather than being silently reimported.
+var replacementSensitiveProperties = map[string][]string{
+	"AWS::EC2::LaunchTemplate":           {"LaunchTemplateData"},
+	"AWS::AutoScaling::AutoScalingGroup": {"LaunchTemplate", "MixedInstancesPolicy"},
+	"AWS::EC2::Instance":                 {"ImageId", "InstanceType"},
+}
+
+// globallyReplacementSensitiveProperties lists property names that force
+// replacement on most AWS resource types regardless of what's in
+// replacementSensitiveProperties, so an unlisted resource type doesn't fall
+// through to ReimportSafe just because nobody added an entry for it.
+var globallyReplacementSensitiveProperties = []string{
+	"VpcId", "SubnetId", "AvailabilityZone", "GroupName", "KeyName",
+}
+
+// DriftedResource is a single resource CloudFormation reported as drifted,
+// normalized from DescribeStackResourceDrifts and classified into a
+// DriftRemediation.
+type DriftedResource struct {
+	LogicalResourceID        string
+	PhysicalResourceID       string
+	ResourceType             string
+	StackResourceDriftStatus cfntypes.StackResourceDriftStatus
+	PropertyDifferences      []cfntypes.PropertyDifference
+	Remediation              DriftRemediation
+}
+
+// DriftResult is the normalized result of running CFN's DetectStackDrift
+// and DescribeStackResourceDrifts against a single stack.
+type DriftResult struct {
+	StackID     string
+	StackName   string
+	DriftStatus cfntypes.StackDriftStatus
+	Drifted     []DriftedResource
+}
+
+// RemediateOptions controls how NewTaskToRemediateDrift treats drift found
+// on a stack.
+type RemediateOptions struct {
+	// DryRun builds the task tree but makes its tasks do nothing: no
+	// change-set is created, executed or returned.
+	DryRun bool
+	// Approve must be true for resources classified as
+	// DriftRemediationRequiresReplacement to be acted on; otherwise they
+	// are reported but skipped.
+	Approve bool
+}
+
+// DetectStackDrift runs CloudFormation's DetectStackDrift against s, waits
+// for detection to finish, and normalizes the result via
+// DescribeStackResourceDrifts.
+func (c *StackCollection) DetectStackDrift(ctx context.Context, s *Stack) (*DriftResult, error) {
+	driftedResources, err := c.describeStackResourceDrifts(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("detecting drift for stack %q: %w", *s.StackName, err)
+	}
+
+	result := &DriftResult{
+		StackID:   *s.StackId,
+		StackName: *s.StackName,
+	}
+	for _, resource := range driftedResources {
+		result.Drifted = append(result.Drifted, classifyDrift(resource))
+	}
+	if len(result.Drifted) > 0 {
+		result.DriftStatus = cfntypes.StackDriftStatusDrifted
+	} else {
+		result.DriftStatus = cfntypes.StackDriftStatusInSync
+	}
+	return result, nil
+}
+
+// DetectAllDrift runs DetectStackDrift across every cluster, nodegroup and
+// IAM addon stack, keyed by stack name. Each stack's drift detection can
+// take minutes, so stacks are checked concurrently rather than one at a
+// time.
+func (c *StackCollection) DetectAllDrift(ctx context.Context) (map[string]*DriftResult, error) {
+	clusterStack, err := c.DescribeClusterStackIfExists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("describing cluster stack: %w", err)
+	}
+	nodeGroupStacks, err := c.ListNodeGroupStacks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing nodegroup stacks: %w", err)
+	}
+	iamStacks, err := c.GetIAMAddonsStacks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing IAM addon stacks: %w", err)
+	}
+
+	allStacks := append(nodeGroupStacks, iamStacks...)
+	if clusterStack != nil {
+		allStacks = append(allStacks, clusterStack)
+	}
+
+	type driftOutcome struct {
+		stackName string
+		result    *DriftResult
+		err       error
+	}
+	outcomes := make(chan driftOutcome, len(allStacks))
+
+	var wg sync.WaitGroup
+	for _, s := range allStacks {
+		wg.Add(1)
+		go func(s *Stack) {
+			defer wg.Done()
+			result, err := c.DetectStackDrift(ctx, s)
+			outcomes <- driftOutcome{stackName: *s.StackName, result: result, err: err}
+		}(s)
+	}
+	go func() {
+		wg.Wait()
+		close(outcomes)
+	}()
+
+	results := make(map[string]*DriftResult, len(allStacks))
+	var firstErr error
+	for outcome := range outcomes {
+		if outcome.err != nil {
+			if firstErr == nil {
+				firstErr = outcome.err
+			}
+			continue
+		}
+		results[outcome.stackName] = outcome.result
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+// NewTaskToRemediateDrift detects drift on s and builds a task tree that
+// remediates each drifted resource according to its classification:
+// reimporting safe drift, emitting an approval-gated change-set for
+// replacements, and patching the template to accept out-of-band changes.
+// After a remediation succeeds, its task calls LookupCloudTrailEvents for s
+// and fails if that lookup fails; the events are not kept, and failed or
+// dry-run attempts make no such call. With opts.DryRun, the tasks do
+// nothing: no change-set is created, executed or returned.
+//
+// It also returns the full *DriftResult, so a resource classified
+// DriftRemediationRequiresReplacement while opts.Approve is false - which
+// gets no task, since remediating it isn't safe to run unattended - is
+// still visible to the caller as something that needs a change-set
+// approved, the same way NewTasksToAdoptNodeGroups returns an
+// *AdoptionReport per group alongside its task tree.
+func (c *StackCollection) NewTaskToRemediateDrift(ctx context.Context, s *Stack, opts RemediateOptions) (*tasks.TaskTree, *DriftResult, error) {
+	driftResult, err := c.DetectStackDrift(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	taskTree := &tasks.TaskTree{Parallel: false}
+	for _, resource := range driftResult.Drifted {
+		if resource.Remediation == DriftRemediationRequiresReplacement && !opts.Approve {
+			continue
+		}
+		taskTree.Append(&remediateDriftTask{
+			info:         fmt.Sprintf("remediate drift on %q (%s)", resource.LogicalResourceID, resource.Remediation),
+			ctx:          ctx,
+			stackManager: c,
+			stack:        s,
+			resource:     resource,
+			dryRun:       opts.DryRun,
+		})
+	}
+	return taskTree, driftResult, nil
+}
+
+type remediateDriftTask struct {
+	info         string
+	ctx          context.Context
+	stackManager *StackCollection
+	stack        *Stack
+	resource     DriftedResource
+	dryRun       bool
+}
+
+func (t *remediateDriftTask) Describe() string { return t.info }
+
+func (t *remediateDriftTask) Do(errorCh chan error) error {
+	defer close(errorCh)
+	if t.dryRun {
+		return nil
+	}
+	if err := t.stackManager.applyDriftRemediation(t.ctx, t.stack, t.resource); err != nil {
+		return fmt.Errorf("remediating drift on %q in stack %q: %w", t.resource.LogicalResourceID, *t.stack.StackName, err)
+	}
+	if _, err := t.stackManager.LookupCloudTrailEvents(t.ctx, t.stack); err != nil {
+		return fmt.Errorf("recording audit trail for drift remediation on %q: %w", *t.stack.StackName, err)
+	}
+	return nil
+}
+
+// describeStackResourceDrifts triggers CFN's DetectStackDrift for s, waits
+// for it to complete, then returns the per-resource drift entries via
+// DescribeStackResourceDrifts, skipping resources CFN reports as in-sync
+// or not yet checked.
+func (c *StackCollection) describeStackResourceDrifts(ctx context.Context, s *Stack) ([]cfntypes.StackResourceDrift, error) {
+	detectOut, err := c.cloudformationAPI.DetectStackDrift(ctx, &cloudformation.DetectStackDriftInput{StackName: s.StackName})
+	if err != nil {
+		return nil, fmt.Errorf("starting drift detection for stack %q: %w", *s.StackName, err)
+	}
+	if err := c.waitForDriftDetection(ctx, detectOut.StackDriftDetectionId); err != nil {
+		return nil, err
+	}
+
+	var drifts []cfntypes.StackResourceDrift
+	var nextToken *string
+	for {
+		out, err := c.cloudformationAPI.DescribeStackResourceDrifts(ctx, &cloudformation.DescribeStackResourceDriftsInput{
+			StackName: s.StackName,
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("describing resource drifts for stack %q: %w", *s.StackName, err)
+		}
+		for _, d := range out.StackResourceDrifts {
+			if d.StackResourceDriftStatus == cfntypes.StackResourceDriftStatusInSync || d.StackResourceDriftStatus == cfntypes.StackResourceDriftStatusNotChecked {
+				continue
+			}
+			drifts = append(drifts, d)
+		}
+		if out.NextToken == nil {
+			break
+		}
+		nextToken = out.NextToken
+	}
+	return drifts, nil
+}
+
+// waitForDriftDetection polls DescribeStackDriftDetectionStatus until the
+// detection identified by detectionID completes, fails, or times out.
+func (c *StackCollection) waitForDriftDetection(ctx context.Context, detectionID *string) error {
+	deadline := time.Now().Add(driftDetectionTimeout)
+	for {
+		out, err := c.cloudformationAPI.DescribeStackDriftDetectionStatus(ctx, &cloudformation.DescribeStackDriftDetectionStatusInput{
+			StackDriftDetectionId: detectionID,
+		})
+		if err != nil {
+			return fmt.Errorf("checking drift detection status: %w", err)
+		}
+		switch out.DetectionStatus {
+		case cfntypes.StackDriftDetectionStatusDetectionComplete:
+			return nil
+		case cfntypes.StackDriftDetectionStatusDetectionFailed:
+			return fmt.Errorf("drift detection failed: %s", aws.ToString(out.DetectionStatusReason))
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for drift detection to complete")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(driftDetectionPollInterval):
+		}
+	}
+}
+
+// classifyDrift maps a raw CFN resource drift into one of the three
+// remediation classes described in NewTaskToRemediateDrift's doc comment.
+func classifyDrift(resource cfntypes.StackResourceDrift) DriftedResource {
+	return DriftedResource{
+		LogicalResourceID:        *resource.LogicalResourceId,
+		PhysicalResourceID:       aws.ToString(resource.PhysicalResourceId),
+		ResourceType:             *resource.ResourceType,
+		StackResourceDriftStatus: resource.StackResourceDriftStatus,
+		PropertyDifferences:      resource.PropertyDifferences,
+		Remediation:              classifyDriftRemediation(*resource.ResourceType, resource.StackResourceDriftStatus, resource.PropertyDifferences),
+	}
+}
+
+// classifyDriftRemediation is the pure decision logic behind classifyDrift:
+// a deleted resource always requires replacement; drift confined to tags
+// is accepted as live state; drift touching a resource type's
+// replacement-sensitive properties requires replacement; everything else
+// (additive changes like security group rules or route entries) is folded
+// back into the stack via reimport.
+func classifyDriftRemediation(resourceType string, status cfntypes.StackResourceDriftStatus, diffs []cfntypes.PropertyDifference) DriftRemediation {
+	if status == cfntypes.StackResourceDriftStatusDeleted {
+		return DriftRemediationRequiresReplacement
+	}
+	if len(diffs) == 0 {
+		return DriftRemediationAcceptLiveState
+	}
+
+	allTags := true
+	for _, diff := range diffs {
+		if diff.PropertyPath == nil || !strings.HasPrefix(*diff.PropertyPath, "/Tags") {
+			allTags = false
+			break
+		}
+	}
+	if allTags {
+		return DriftRemediationAcceptLiveState
+	}
+
+	sensitiveProperties := append(append([]string{}, replacementSensitiveProperties[resourceType]...), globallyReplacementSensitiveProperties...)
+	for _, sensitivePath := range sensitiveProperties {
+		for _, diff := range diffs {
+			if diff.PropertyPath != nil && strings.HasPrefix(strings.TrimPrefix(*diff.PropertyPath, "/"), sensitivePath) {
+				return DriftRemediationRequiresReplacement
+			}
+		}
+	}
+	return DriftRemediationReimport
+}
+
+// applyDriftRemediation applies the remediation classified for resource:
+// an update change-set with the stack's own template for safe drift (folding
+// out-of-band additions back to what the template declares), a
+// user-approved change-set execution for replacements, or a template patch
+// that accepts the live state.
+func (c *StackCollection) applyDriftRemediation(ctx context.Context, s *Stack, resource DriftedResource) error {
+	switch resource.Remediation {
+	case DriftRemediationReimport:
+		return c.reimportDriftedResource(ctx, s, resource)
+	case DriftRemediationRequiresReplacement:
+		return c.executeReplacementChangeSet(ctx, s, resource)
+	case DriftRemediationAcceptLiveState:
+		return c.acceptDriftedResourceLiveState(ctx, s, resource)
+	default:
+		return fmt.Errorf("unknown drift remediation %q", resource.Remediation)
+	}
+}
+
+// reimportDriftedResource re-applies s's own template via a change-set, so
+// a resource whose drift is confined to template-declared properties (e.g.
+// an out-of-band security group rule addition) is folded back in line with
+// it. Re-submitting an unmodified template only has an effect when CFN's
+// change-set computation actually finds a difference between what's live
+// and what the template declares; when it doesn't, that's surfaced as an
+// error rather than silently reporting the (untouched) drift as resolved.
+func (c *StackCollection) reimportDriftedResource(ctx context.Context, s *Stack, resource DriftedResource) error {
+	changeSetName := c.MakeChangeSetName(fmt.Sprintf("drift-reimport-%s", resource.LogicalResourceID))
+
+	if _, err := c.cloudformationAPI.CreateChangeSet(ctx, &cloudformation.CreateChangeSetInput{
+		StackName:           s.StackName,
+		ChangeSetName:       aws.String(changeSetName),
+		UsePreviousTemplate: aws.Bool(true),
+		ChangeSetType:       cfntypes.ChangeSetTypeUpdate,
+	}); err != nil {
+		return fmt.Errorf("creating change set to reimport %q: %w", resource.LogicalResourceID, err)
+	}
+
+	describeInput := &cloudformation.DescribeChangeSetInput{
+		StackName:     s.StackName,
+		ChangeSetName: aws.String(changeSetName),
+	}
+	waiter := cloudformation.NewChangeSetCreateCompleteWaiter(c.cloudformationAPI)
+	if err := waiter.Wait(ctx, describeInput, changeSetWaitTimeout); err != nil {
+		out, describeErr := c.cloudformationAPI.DescribeChangeSet(ctx, describeInput)
+		if describeErr == nil && out.Status == cfntypes.ChangeSetStatusFailed && strings.Contains(aws.ToString(out.StatusReason), "didn't contain changes") {
+			_, _ = c.cloudformationAPI.DeleteChangeSet(ctx, &cloudformation.DeleteChangeSetInput{
+				StackName:     s.StackName,
+				ChangeSetName: aws.String(changeSetName),
+			})
+			return fmt.Errorf("reimporting %q found no template-level difference for CloudFormation to reconcile against; this drift needs a manual fix", resource.LogicalResourceID)
+		}
+		return fmt.Errorf("waiting for change set to reimport %q: %w", resource.LogicalResourceID, err)
+	}
+
+	if _, err := c.cloudformationAPI.ExecuteChangeSet(ctx, &cloudformation.ExecuteChangeSetInput{
+		StackName:     s.StackName,
+		ChangeSetName: aws.String(changeSetName),
+	}); err != nil {
+		return fmt.Errorf("executing change set to reimport %q: %w", resource.LogicalResourceID, err)
+	}
+	return nil
+}
+
+// executeReplacementChangeSet creates and, once CFN has finished computing
+// it, executes a change-set against s's own template. Because the caller
+// only reaches here for resources opts.Approve has cleared, this amounts
+// to an explicitly user-approved nudge for CFN to reconcile the resource -
+// AWS does not expose an API to force a specific resource's replacement
+// outside of a template change, so operators whose drift needs an actual
+// property edit still need to update the template themselves.
+func (c *StackCollection) executeReplacementChangeSet(ctx context.Context, s *Stack, resource DriftedResource) error {
+	changeSetName := c.MakeChangeSetName(fmt.Sprintf("drift-remediation-%s", resource.LogicalResourceID))
+
+	if _, err := c.cloudformationAPI.CreateChangeSet(ctx, &cloudformation.CreateChangeSetInput{
+		StackName:           s.StackName,
+		ChangeSetName:       aws.String(changeSetName),
+		UsePreviousTemplate: aws.Bool(true),
+		ChangeSetType:       cfntypes.ChangeSetTypeUpdate,
+	}); err != nil {
+		return fmt.Errorf("creating change set to replace %q: %w", resource.LogicalResourceID, err)
+	}
+
+	waiter := cloudformation.NewChangeSetCreateCompleteWaiter(c.cloudformationAPI)
+	if err := waiter.Wait(ctx, &cloudformation.DescribeChangeSetInput{
+		StackName:     s.StackName,
+		ChangeSetName: aws.String(changeSetName),
+	}, changeSetWaitTimeout); err != nil {
+		return fmt.Errorf("waiting for change set to replace %q: %w", resource.LogicalResourceID, err)
+	}
+
+	if _, err := c.cloudformationAPI.ExecuteChangeSet(ctx, &cloudformation.ExecuteChangeSetInput{
+		StackName:     s.StackName,
+		ChangeSetName: aws.String(changeSetName),
+	}); err != nil {
+		return fmt.Errorf("executing change set to replace %q: %w", resource.LogicalResourceID, err)
+	}
+	return nil
+}
+
+// acceptDriftedResourceLiveState patches s's template so resource's
+// properties match what CFN observed live, then applies that patched
+// template - the template itself changes to accept the drift rather than
+// the resource being touched.
+func (c *StackCollection) acceptDriftedResourceLiveState(ctx context.Context, s *Stack, resource DriftedResource) error {
+	templateBody, err := c.GetStackTemplate(ctx, *s.StackName)
+	if err != nil {
+		return fmt.Errorf("fetching template to accept live state of %q: %w", resource.LogicalResourceID, err)
+	}
+
+	patched, err := patchTemplatePropertiesFromDrift(templateBody, resource)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.cloudformationAPI.UpdateStack(ctx, &cloudformation.UpdateStackInput{
+		StackName:    s.StackName,
+		TemplateBody: aws.String(patched),
+	})
+	if err != nil && !isNoUpdatesToPerformError(err) {
+		return fmt.Errorf("applying patched template to accept live state of %q: %w", resource.LogicalResourceID, err)
+	}
+	return nil
+}
+
+// patchTemplatePropertiesFromDrift returns a copy of templateBody with
+// resource's PropertyDifferences applied to its Properties block, so the
+// template matches what's actually deployed. It is a pure function so the
+// property-path patching logic can be unit tested without an AWS call.
+func patchTemplatePropertiesFromDrift(templateBody string, resource DriftedResource) (string, error) {
+	var template map[string]interface{}
+	if err := json.Unmarshal([]byte(templateBody), &template); err != nil {
+		return "", fmt.Errorf("parsing template to accept live state of %q: %w", resource.LogicalResourceID, err)
+	}
+
+	resources, ok := template["Resources"].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("template has no Resources section")
+	}
+	res, ok := resources[resource.LogicalResourceID].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("template has no resource %q", resource.LogicalResourceID)
+	}
+	var properties interface{} = res["Properties"]
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+
+	for _, diff := range resource.PropertyDifferences {
+		if diff.PropertyPath == nil {
+			continue
+		}
+		segments := strings.Split(strings.TrimPrefix(*diff.PropertyPath, "/"), "/")
+		updated, err := applyPropertyDiff(properties, segments, diff.DifferenceType, actualValue(diff.ActualValue))
+		if err != nil {
+			return "", fmt.Errorf("applying drift for %q at %q: %w", resource.LogicalResourceID, *diff.PropertyPath, err)
+		}
+		properties = updated
+	}
+	res["Properties"] = properties
+
+	patched, err := json.Marshal(template)
+	if err != nil {
+		return "", fmt.Errorf("re-encoding patched template: %w", err)
+	}
+	return string(patched), nil
+}
+
+// actualValue converts a CFN PropertyDifference's ActualValue - a string
+// that is itself JSON-encoded for non-scalar properties - into the Go
+// value applyPropertyDiff should store, falling back to the raw string
+// when it isn't valid JSON.
+func actualValue(raw *string) interface{} {
+	if raw == nil {
+		return nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(*raw), &decoded); err == nil {
+		return decoded
+	}
+	return *raw
+}
+
+// applyPropertyDiff returns a copy of node with value applied at the
+// slash-separated path in segments (from a CFN PropertyDifference's
+// PropertyPath, e.g. ["Tags", "0", "Value"]), honoring diffType: Remove
+// deletes the map key or array element, Add inserts into an array at the
+// given index (appending when the index is one past the end), and
+// anything else (NotEqual) overwrites in place. A key missing along the
+// path is created as a map, or as an array when the next segment is a
+// numeric index. It is a pure function so
+// the three-way add/remove/replace handling can be unit tested without an
+// AWS call.
+func applyPropertyDiff(node interface{}, segments []string, diffType cfntypes.DifferenceType, value interface{}) (interface{}, error) {
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("empty property path")
+	}
+	segment, rest := segments[0], segments[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(n))
+		for k, v := range n {
+			out[k] = v
+		}
+		if len(rest) == 0 {
+			if diffType == cfntypes.DifferenceTypeRemove {
+				delete(out, segment)
+			} else {
+				out[segment] = value
+			}
+			return out, nil
+		}
+		updated, err := applyPropertyDiff(out[segment], rest, diffType, value)
+		if err != nil {
+			return nil, err
+		}
+		out[segment] = updated
+		return out, nil
+
+	case []interface{}:
+		idx, err := strconv.Atoi(segment)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid array index", segment)
+		}
+		if len(rest) == 0 {
+			switch diffType {
+			case cfntypes.DifferenceTypeRemove:
+				if idx < 0 || idx >= len(n) {
+					return nil, fmt.Errorf("index %q out of range for array of length %d", segment, len(n))
+				}
+				out := make([]interface{}, 0, len(n)-1)
+				out = append(out, n[:idx]...)
+				out = append(out, n[idx+1:]...)
+				return out, nil
+			case cfntypes.DifferenceTypeAdd:
+				if idx < 0 || idx > len(n) {
+					return nil, fmt.Errorf("index %q out of range for array of length %d", segment, len(n))
+				}
+				out := make([]interface{}, 0, len(n)+1)
+				out = append(out, n[:idx]...)
+				out = append(out, value)
+				out = append(out, n[idx:]...)
+				return out, nil
+			default:
+				if idx < 0 || idx >= len(n) {
+					return nil, fmt.Errorf("index %q out of range for array of length %d", segment, len(n))
+				}
+				out := make([]interface{}, len(n))
+				copy(out, n)
+				out[idx] = value
+				return out, nil
+			}
+		}
+		if idx < 0 || idx >= len(n) {
+			return nil, fmt.Errorf("index %q out of range for array of length %d", segment, len(n))
+		}
+		updated, err := applyPropertyDiff(n[idx], rest, diffType, value)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, len(n))
+		copy(out, n)
+		out[idx] = updated
+		return out, nil
+
+	case nil:
+		// The path runs through a key the template doesn't have yet: build
+		// the container segment indexes into and apply the diff inside it.
+		if _, err := strconv.Atoi(segment); err == nil {
+			return applyPropertyDiff([]interface{}{}, segments, diffType, value)
+		}
+		return applyPropertyDiff(map[string]interface{}{}, segments, diffType, value)
+
+	default:
+		if len(rest) == 0 {
+			if diffType == cfntypes.DifferenceTypeRemove {
+				return nil, fmt.Errorf("cannot remove from a scalar value at %q", segment)
+			}
+			return value, nil
+		}
+		return nil, fmt.Errorf("cannot descend into %T at segment %q", node, segment)
+	}
+}
@@ -0,0 +1,216 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cfntypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func propertyDiff(path, actual string) cfntypes.PropertyDifference {
+	return cfntypes.PropertyDifference{
+		PropertyPath:   aws.String(path),
+		ActualValue:    aws.String(actual),
+		DifferenceType: cfntypes.DifferenceTypeNotEqual,
+	}
+}
+
+func TestClassifyDriftRemediation(t *testing.T) {
+	tests := []struct {
+		name         string
+		resourceType string
+		status       cfntypes.StackResourceDriftStatus
+		diffs        []cfntypes.PropertyDifference
+		want         DriftRemediation
+	}{
+		{
+			name:         "deleted resource always requires replacement",
+			resourceType: "AWS::EC2::SecurityGroup",
+			status:       cfntypes.StackResourceDriftStatusDeleted,
+			want:         DriftRemediationRequiresReplacement,
+		},
+		{
+			name:         "no property differences is accepted as live state",
+			resourceType: "AWS::EC2::SecurityGroup",
+			status:       cfntypes.StackResourceDriftStatusModified,
+			diffs:        nil,
+			want:         DriftRemediationAcceptLiveState,
+		},
+		{
+			name:         "tag-only drift is accepted as live state",
+			resourceType: "AWS::EC2::SecurityGroup",
+			status:       cfntypes.StackResourceDriftStatusModified,
+			diffs:        []cfntypes.PropertyDifference{propertyDiff("/Tags/0/Value", "dev")},
+			want:         DriftRemediationAcceptLiveState,
+		},
+		{
+			name:         "launch template data drift requires replacement",
+			resourceType: "AWS::EC2::LaunchTemplate",
+			status:       cfntypes.StackResourceDriftStatusModified,
+			diffs:        []cfntypes.PropertyDifference{propertyDiff("/LaunchTemplateData/ImageId", "ami-new")},
+			want:         DriftRemediationRequiresReplacement,
+		},
+		{
+			name:         "security group rule drift is reimported",
+			resourceType: "AWS::EC2::SecurityGroup",
+			status:       cfntypes.StackResourceDriftStatusModified,
+			diffs:        []cfntypes.PropertyDifference{propertyDiff("/SecurityGroupIngress/0/CidrIp", "0.0.0.0/0")},
+			want:         DriftRemediationReimport,
+		},
+		{
+			name:         "globally-sensitive property on an unlisted resource type requires replacement",
+			resourceType: "AWS::RDS::DBSubnetGroup",
+			status:       cfntypes.StackResourceDriftStatusModified,
+			diffs:        []cfntypes.PropertyDifference{propertyDiff("/SubnetId", "subnet-new")},
+			want:         DriftRemediationRequiresReplacement,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyDriftRemediation(tt.resourceType, tt.status, tt.diffs); got != tt.want {
+				t.Errorf("classifyDriftRemediation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPropertyDiffReplace(t *testing.T) {
+	root := map[string]interface{}{
+		"Tags": []interface{}{
+			map[string]interface{}{"Key": "env", "Value": "prod"},
+		},
+	}
+
+	got, err := applyPropertyDiff(root, []string{"Tags", "0", "Value"}, cfntypes.DifferenceTypeNotEqual, "dev")
+	if err != nil {
+		t.Fatalf("applyPropertyDiff() error = %v", err)
+	}
+
+	tags, ok := got.(map[string]interface{})["Tags"].([]interface{})
+	if !ok || len(tags) != 1 {
+		t.Fatalf("Tags = %v, want a single-element slice", got.(map[string]interface{})["Tags"])
+	}
+	tag, ok := tags[0].(map[string]interface{})
+	if !ok || tag["Value"] != "dev" {
+		t.Errorf("Tags[0] = %v, want Value=dev", tags[0])
+	}
+	// the input must not be mutated in place.
+	originalTag := root["Tags"].([]interface{})[0].(map[string]interface{})
+	if originalTag["Value"] != "prod" {
+		t.Errorf("applyPropertyDiff mutated its input: %v", originalTag)
+	}
+}
+
+func TestApplyPropertyDiffCreatesIntermediateMaps(t *testing.T) {
+	got, err := applyPropertyDiff(map[string]interface{}{}, []string{"LaunchTemplateData", "ImageId"}, cfntypes.DifferenceTypeNotEqual, "ami-0123456789abcdef0")
+	if err != nil {
+		t.Fatalf("applyPropertyDiff() error = %v", err)
+	}
+	if data, ok := got.(map[string]interface{})["LaunchTemplateData"].(map[string]interface{}); !ok || data["ImageId"] != "ami-0123456789abcdef0" {
+		t.Errorf("LaunchTemplateData = %v, want a map with ImageId=ami-0123456789abcdef0", got.(map[string]interface{})["LaunchTemplateData"])
+	}
+}
+
+func TestApplyPropertyDiffOutOfRangeIndex(t *testing.T) {
+	root := map[string]interface{}{"Tags": []interface{}{}}
+	if _, err := applyPropertyDiff(root, []string{"Tags", "0", "Value"}, cfntypes.DifferenceTypeNotEqual, "dev"); err == nil {
+		t.Fatal("expected an error for an out-of-range array index on a NotEqual diff")
+	}
+}
+
+func TestApplyPropertyDiffAddAppendsAtEndOfArray(t *testing.T) {
+	root := map[string]interface{}{
+		"Tags": []interface{}{
+			map[string]interface{}{"Key": "env", "Value": "prod"},
+		},
+	}
+	newTag := map[string]interface{}{"Key": "owner", "Value": "team-a"}
+
+	got, err := applyPropertyDiff(root, []string{"Tags", "1"}, cfntypes.DifferenceTypeAdd, newTag)
+	if err != nil {
+		t.Fatalf("applyPropertyDiff() error = %v", err)
+	}
+	tags := got.(map[string]interface{})["Tags"].([]interface{})
+	if len(tags) != 2 {
+		t.Fatalf("len(Tags) = %d, want 2", len(tags))
+	}
+	if tags[1].(map[string]interface{})["Key"] != "owner" {
+		t.Errorf("Tags[1] = %v, want the newly added tag", tags[1])
+	}
+}
+
+func TestApplyPropertyDiffRemoveDeletesArrayElement(t *testing.T) {
+	root := map[string]interface{}{
+		"Tags": []interface{}{
+			map[string]interface{}{"Key": "env", "Value": "prod"},
+			map[string]interface{}{"Key": "owner", "Value": "team-a"},
+		},
+	}
+
+	got, err := applyPropertyDiff(root, []string{"Tags", "1"}, cfntypes.DifferenceTypeRemove, nil)
+	if err != nil {
+		t.Fatalf("applyPropertyDiff() error = %v", err)
+	}
+	tags := got.(map[string]interface{})["Tags"].([]interface{})
+	if len(tags) != 1 {
+		t.Fatalf("len(Tags) = %d, want 1", len(tags))
+	}
+	if tags[0].(map[string]interface{})["Key"] != "env" {
+		t.Errorf("Tags[0] = %v, want the remaining env tag", tags[0])
+	}
+}
+
+func TestApplyPropertyDiffRemoveDeletesMapKey(t *testing.T) {
+	root := map[string]interface{}{"Description": "old"}
+
+	got, err := applyPropertyDiff(root, []string{"Description"}, cfntypes.DifferenceTypeRemove, nil)
+	if err != nil {
+		t.Fatalf("applyPropertyDiff() error = %v", err)
+	}
+	if _, exists := got.(map[string]interface{})["Description"]; exists {
+		t.Errorf("Description still present after Remove: %v", got)
+	}
+}
+
+func TestPatchTemplatePropertiesFromDrift(t *testing.T) {
+	template := `{
+		"Resources": {
+			"SG": {
+				"Type": "AWS::EC2::SecurityGroup",
+				"Properties": {
+					"GroupDescription": "test",
+					"Tags": [{"Key": "env", "Value": "prod"}]
+				}
+			}
+		}
+	}`
+	resource := DriftedResource{
+		LogicalResourceID:   "SG",
+		PropertyDifferences: []cfntypes.PropertyDifference{propertyDiff("/Tags/0/Value", "dev")},
+	}
+
+	patched, err := patchTemplatePropertiesFromDrift(template, resource)
+	if err != nil {
+		t.Fatalf("patchTemplatePropertiesFromDrift() error = %v", err)
+	}
+	if !contains(patched, `"Value":"dev"`) && !contains(patched, `"Value": "dev"`) {
+		t.Errorf("patched template = %s, want it to contain the patched tag value", patched)
+	}
+}
+
+func TestPatchTemplatePropertiesFromDriftMissingResource(t *testing.T) {
+	_, err := patchTemplatePropertiesFromDrift(`{"Resources": {}}`, DriftedResource{LogicalResourceID: "SG"})
+	if err == nil {
+		t.Fatal("expected an error when the logical resource isn't present in the template")
+	}
+}
+
+func contains(haystack, needle string) bool {
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		if haystack[i:i+len(needle)] == needle {
+			return true
+		}
+	}
+	return false
+}
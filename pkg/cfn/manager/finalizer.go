@@ -0,0 +1,271 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cfntypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+
+	"github.com/weaveworks/eksctl/pkg/utils/tasks"
+)
+
+// cleanupRequiredTagKey is set to "true" in the tags of the CreateStack
+// request DoCreateStackRequest issues, so a stack carries it from the
+// moment it exists. DeleteStackSync drops it from the local Stack once CFN
+// reports the stack deleted; no non-CFN side-effects (IAM OIDC provider
+// entries, aws-auth entries, addon IRSA, Fargate profiles) are torn down
+// before that. Its presence on a stack whose parent cluster or nodegroup
+// no longer exists in EKS marks it as orphaned.
+const cleanupRequiredTagKey = "alpha.eksctl.io/cleanup-required"
+
+// deleteWaitTimeout bounds how long DeleteStackSync waits for CFN to finish
+// tearing down a stack.
+const deleteWaitTimeout = 30 * time.Minute
+
+// HasPendingCleanup reports whether s still carries the cleanup-required
+// finalizer tag, i.e. whether non-CFN side-effects may still need tearing
+// down before s is safe to forget about.
+func (c *StackCollection) HasPendingCleanup(s *Stack) bool {
+	for _, tag := range s.Tags {
+		if tag.Key != nil && *tag.Key == cleanupRequiredTagKey {
+			return tag.Value != nil && *tag.Value == "true"
+		}
+	}
+	return false
+}
+
+// DoCreateStackRequest issues the CreateStack call for i. The
+// cleanup-required finalizer tag is added to the stack's tags before the
+// request is made, so that if the process dies partway through creation,
+// NewTaskToReconcileOrphanedStacks can find i and reap whatever it left
+// behind.
+func (c *StackCollection) DoCreateStackRequest(ctx context.Context, i *Stack, templateData TemplateData, tags, parameters map[string]string, withIAM, withNamedIAM bool) error {
+	stackTags := withCleanupRequiredTag(tags)
+
+	input := &cloudformation.CreateStackInput{
+		StackName:  i.StackName,
+		Tags:       tagsFromMap(stackTags),
+		Parameters: parametersFromMap(parameters),
+	}
+	switch body := templateData.(type) {
+	case TemplateBody:
+		input.TemplateBody = aws.String(string(body))
+	case TemplateURL:
+		input.TemplateURL = aws.String(string(body))
+	}
+	if withNamedIAM {
+		input.Capabilities = []cfntypes.Capability{cfntypes.CapabilityCapabilityNamedIam}
+	} else if withIAM {
+		input.Capabilities = []cfntypes.Capability{cfntypes.CapabilityCapabilityIam}
+	}
+
+	out, err := c.cloudformationAPI.CreateStack(ctx, input)
+	if err != nil {
+		return fmt.Errorf("requesting creation of stack %q: %w", *i.StackName, err)
+	}
+	i.StackId = out.StackId
+	return nil
+}
+
+// DeleteStackBySpec issues the CFN DeleteStack call for s. It does not
+// touch the cleanup-required finalizer tag: DeleteStack only requests
+// teardown, it does not confirm it, and a stack that lands in
+// DELETE_FAILED must still carry the tag so NewTaskToReconcileOrphanedStacks
+// can find it. The tag is only cleared once DeleteStackSync has confirmed
+// the stack is actually gone.
+func (c *StackCollection) DeleteStackBySpec(ctx context.Context, s *Stack) (*Stack, error) {
+	if _, err := c.cloudformationAPI.DeleteStack(ctx, &cloudformation.DeleteStackInput{StackName: s.StackName}); err != nil {
+		return nil, fmt.Errorf("deleting stack %q: %w", *s.StackName, err)
+	}
+	return s, nil
+}
+
+// DeleteStackSync calls DeleteStackBySpec and blocks until CFN reports the
+// stack gone, only then clearing s's cleanup-required tag. A stack that
+// never reaches DELETE_COMPLETE (DELETE_FAILED, or a wait that times out)
+// keeps the tag, leaving it a candidate for NewTaskToReconcileOrphanedStacks.
+func (c *StackCollection) DeleteStackSync(ctx context.Context, s *Stack) error {
+	if _, err := c.DeleteStackBySpec(ctx, s); err != nil {
+		return err
+	}
+	waiter := cloudformation.NewStackDeleteCompleteWaiter(c.cloudformationAPI)
+	if err := waiter.Wait(ctx, &cloudformation.DescribeStacksInput{StackName: s.StackName}, deleteWaitTimeout); err != nil {
+		return fmt.Errorf("waiting for stack %q to be deleted: %w", *s.StackName, err)
+	}
+	// The stack is gone from CFN at this point, so there's nothing left to
+	// call UpdateStack against; just keep s's local tags consistent.
+	s.Tags = withoutCleanupRequiredTag(s.Tags)
+	return nil
+}
+
+// withCleanupRequiredTag returns a copy of tags with the cleanup-required
+// finalizer tag set, leaving the input untouched. It is a pure function so
+// the tag-merging logic can be unit tested without talking to AWS.
+func withCleanupRequiredTag(tags map[string]string) map[string]string {
+	merged := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		merged[k] = v
+	}
+	merged[cleanupRequiredTagKey] = "true"
+	return merged
+}
+
+// withoutCleanupRequiredTag returns a copy of tags with the
+// cleanup-required finalizer tag removed, leaving the input untouched.
+func withoutCleanupRequiredTag(tags []cfntypes.Tag) []cfntypes.Tag {
+	remaining := make([]cfntypes.Tag, 0, len(tags))
+	for _, tag := range tags {
+		if tag.Key != nil && *tag.Key == cleanupRequiredTagKey {
+			continue
+		}
+		remaining = append(remaining, tag)
+	}
+	return remaining
+}
+
+// isNoUpdatesToPerformError reports whether err is CFN's "No updates are to
+// be performed" error, which UpdateStack can return even when only tags
+// changed. It isn't a real failure, so callers should treat it as success.
+func isNoUpdatesToPerformError(err error) bool {
+	var apiErr interface{ ErrorMessage() string }
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorMessage() == "No updates are to be performed."
+	}
+	return false
+}
+
+// NewTaskToReconcileOrphanedStacks lists stacks in CREATE_FAILED,
+// ROLLBACK_COMPLETE or DELETE_FAILED status that still carry the
+// cleanup-required finalizer tag but whose parent cluster or nodegroup no
+// longer exists in EKS, and runs the cleanup path against each of them.
+// Stacks in any other status are not checked.
+// This closes the class of bugs where an interrupted `eksctl create`
+// leaves IAM roles, ENIs or security groups behind with no way to reap
+// them safely.
+func (c *StackCollection) NewTaskToReconcileOrphanedStacks(ctx context.Context) (*tasks.TaskTree, error) {
+	candidates, err := c.ListStacksWithStatuses(ctx,
+		cfntypes.StackStatusCreateFailed,
+		cfntypes.StackStatusRollbackComplete,
+		cfntypes.StackStatusDeleteFailed,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing stacks to check for orphaned finalizers: %w", err)
+	}
+
+	taskTree := &tasks.TaskTree{Parallel: true}
+	for _, s := range candidates {
+		if !c.HasPendingCleanup(s) {
+			continue
+		}
+		orphaned, err := c.isOrphaned(ctx, s)
+		if err != nil {
+			return nil, fmt.Errorf("checking whether stack %q is orphaned: %w", *s.StackName, err)
+		}
+		if !orphaned {
+			continue
+		}
+		taskTree.Append(&reconcileOrphanedStackTask{
+			info:         fmt.Sprintf("reconcile orphaned stack %q", *s.StackName),
+			ctx:          ctx,
+			stackManager: c,
+			stack:        s,
+		})
+	}
+	return taskTree, nil
+}
+
+// isOrphaned reports whether s's parent cluster or nodegroup no longer
+// exists in EKS, meaning the process that created s was interrupted before
+// it could finish and clear the cleanup-required tag itself.
+func (c *StackCollection) isOrphaned(ctx context.Context, s *Stack) (bool, error) {
+	clusterName := c.spec.Metadata.Name
+
+	if nodeGroupName := c.GetNodeGroupName(s); nodeGroupName != "" {
+		_, err := c.eksAPI.DescribeNodegroup(ctx, &eks.DescribeNodegroupInput{
+			ClusterName:   &clusterName,
+			NodegroupName: &nodeGroupName,
+		})
+		return isEKSResourceNotFound(err), nilIfNotFound(err)
+	}
+
+	_, err := c.eksAPI.DescribeCluster(ctx, &eks.DescribeClusterInput{Name: &clusterName})
+	return isEKSResourceNotFound(err), nilIfNotFound(err)
+}
+
+// isEKSResourceNotFound reports whether err is EKS's
+// ResourceNotFoundException, i.e. the cluster or nodegroup the stack
+// belongs to is gone.
+func isEKSResourceNotFound(err error) bool {
+	var notFound *ekstypes.ResourceNotFoundException
+	return errors.As(err, &notFound)
+}
+
+// nilIfNotFound passes through err unless it is a ResourceNotFoundException,
+// which isOrphaned already turns into its boolean return value rather than
+// an error.
+func nilIfNotFound(err error) error {
+	if isEKSResourceNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+type reconcileOrphanedStackTask struct {
+	info         string
+	ctx          context.Context
+	stackManager *StackCollection
+	stack        *Stack
+}
+
+func (t *reconcileOrphanedStackTask) Describe() string { return t.info }
+
+func (t *reconcileOrphanedStackTask) Do(errorCh chan error) error {
+	defer close(errorCh)
+	if err := t.stackManager.DeleteStackSync(t.ctx, t.stack); err != nil {
+		return fmt.Errorf("cleaning up orphaned stack %q: %w", *t.stack.StackName, err)
+	}
+	return nil
+}
+
+// TemplateData is the CFN template payload DoCreateStackRequest and
+// UpdateStack accept: either the template body inline, or the URL of a
+// template eksctl has already uploaded to S3.
+type TemplateData interface {
+	isTemplateData()
+}
+
+// TemplateBody is a CFN template supplied inline.
+type TemplateBody []byte
+
+func (TemplateBody) isTemplateData() {}
+
+// TemplateURL is the S3 URL of a previously-uploaded CFN template.
+type TemplateURL string
+
+func (TemplateURL) isTemplateData() {}
+
+// tagsFromMap converts a plain tag map into the []cfntypes.Tag shape the
+// CloudFormation API expects.
+func tagsFromMap(tags map[string]string) []cfntypes.Tag {
+	out := make([]cfntypes.Tag, 0, len(tags))
+	for k, v := range tags {
+		out = append(out, cfntypes.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return out
+}
+
+// parametersFromMap converts a plain parameter map into the
+// []cfntypes.Parameter shape the CloudFormation API expects.
+func parametersFromMap(parameters map[string]string) []cfntypes.Parameter {
+	out := make([]cfntypes.Parameter, 0, len(parameters))
+	for k, v := range parameters {
+		out = append(out, cfntypes.Parameter{ParameterKey: aws.String(k), ParameterValue: aws.String(v)})
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cfntypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+)
+
+func TestWithCleanupRequiredTag(t *testing.T) {
+	original := map[string]string{"foo": "bar"}
+
+	got := withCleanupRequiredTag(original)
+
+	if len(original) != 1 {
+		t.Errorf("withCleanupRequiredTag mutated its input: %v", original)
+	}
+	if got[cleanupRequiredTagKey] != "true" {
+		t.Errorf("got[%q] = %q, want %q", cleanupRequiredTagKey, got[cleanupRequiredTagKey], "true")
+	}
+	if got["foo"] != "bar" {
+		t.Errorf("got[\"foo\"] = %q, want %q", got["foo"], "bar")
+	}
+}
+
+func TestWithoutCleanupRequiredTag(t *testing.T) {
+	tags := []cfntypes.Tag{
+		{Key: aws.String("foo"), Value: aws.String("bar")},
+		{Key: aws.String(cleanupRequiredTagKey), Value: aws.String("true")},
+	}
+
+	got := withoutCleanupRequiredTag(tags)
+
+	if len(got) != 1 {
+		t.Fatalf("len(got) = %d, want 1", len(got))
+	}
+	if *got[0].Key != "foo" {
+		t.Errorf("got[0].Key = %q, want %q", *got[0].Key, "foo")
+	}
+}
+
+func TestHasPendingCleanup(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []cfntypes.Tag
+		want bool
+	}{
+		{name: "tag present and true", tags: []cfntypes.Tag{{Key: aws.String(cleanupRequiredTagKey), Value: aws.String("true")}}, want: true},
+		{name: "tag present but false", tags: []cfntypes.Tag{{Key: aws.String(cleanupRequiredTagKey), Value: aws.String("false")}}, want: false},
+		{name: "tag absent", tags: nil, want: false},
+	}
+
+	c := &StackCollection{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.HasPendingCleanup(&Stack{Tags: tt.tags}); got != tt.want {
+				t.Errorf("HasPendingCleanup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsEKSResourceNotFound(t *testing.T) {
+	if !isEKSResourceNotFound(&ekstypes.ResourceNotFoundException{}) {
+		t.Error("expected ResourceNotFoundException to be classified as not-found")
+	}
+	if isEKSResourceNotFound(errors.New("boom")) {
+		t.Error("expected an unrelated error not to be classified as not-found")
+	}
+	if isEKSResourceNotFound(nil) {
+		t.Error("expected a nil error not to be classified as not-found")
+	}
+}
+
+func TestNilIfNotFound(t *testing.T) {
+	if err := nilIfNotFound(&ekstypes.ResourceNotFoundException{}); err != nil {
+		t.Errorf("nilIfNotFound(ResourceNotFoundException) = %v, want nil", err)
+	}
+	boom := errors.New("boom")
+	if err := nilIfNotFound(boom); err != boom {
+		t.Errorf("nilIfNotFound(boom) = %v, want %v", err, boom)
+	}
+}
+
+func TestTagsFromMap(t *testing.T) {
+	got := tagsFromMap(map[string]string{"foo": "bar"})
+	if len(got) != 1 || *got[0].Key != "foo" || *got[0].Value != "bar" {
+		t.Errorf("tagsFromMap() = %+v, want a single foo=bar tag", got)
+	}
+}
+
+func TestParametersFromMap(t *testing.T) {
+	got := parametersFromMap(map[string]string{"foo": "bar"})
+	if len(got) != 1 || *got[0].ParameterKey != "foo" || *got[0].ParameterValue != "bar" {
+		t.Errorf("parametersFromMap() = %+v, want a single foo=bar parameter", got)
+	}
+}
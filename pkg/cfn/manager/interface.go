@@ -48,11 +48,14 @@ type StackManager interface {
 	DescribeClusterStackIfExists(ctx context.Context) (*Stack, error)
 	DescribeClusterStack(ctx context.Context) (*Stack, error)
 	DescribeIAMServiceAccountStacks(ctx context.Context) ([]*Stack, error)
+	DescribeNodeGroupInstances(ctx context.Context, s *Stack, clientSetGetter kubernetes.ClientSetGetter) (*NodeGroupInstances, error)
 	DescribeNodeGroupStack(ctx context.Context, nodeGroupName string) (*Stack, error)
 	DescribeNodeGroupStacksAndResources(ctx context.Context) (map[string]StackInfo, error)
 	DescribeStack(ctx context.Context, i *Stack) (*Stack, error)
 	DescribeStackChangeSet(ctx context.Context, i *Stack, changeSetName string) (*ChangeSet, error)
 	DescribeStackEvents(ctx context.Context, i *Stack) ([]cfntypes.StackEvent, error)
+	DetectAllDrift(ctx context.Context) (map[string]*DriftResult, error)
+	DetectStackDrift(ctx context.Context, s *Stack) (*DriftResult, error)
 	DoCreateStackRequest(ctx context.Context, i *Stack, templateData TemplateData, tags, parameters map[string]string, withIAM bool, withNamedIAM bool) error
 	DoWaitUntilStackIsCreated(ctx context.Context, i *Stack) error
 	EnsureMapPublicIPOnLaunchEnabled(ctx context.Context) error
@@ -72,19 +75,26 @@ type StackManager interface {
 	GetStackTemplate(ctx context.Context, stackName string) (string, error)
 	GetUnmanagedNodeGroupAutoScalingGroupName(ctx context.Context, s *Stack) (string, error)
 	HasClusterStackFromList(ctx context.Context, clusterStackNames []string, clusterName string) (bool, error)
+	HasPendingCleanup(s *Stack) bool
 	ListClusterStackNames(ctx context.Context) ([]string, error)
 	ListIAMServiceAccountStacks(ctx context.Context) ([]string, error)
+	ListNodeGroupInstancesNeedingUpdate(ctx context.Context, s *Stack, clientSetGetter kubernetes.ClientSetGetter) ([]CloudInstance, error)
 	ListNodeGroupStacks(ctx context.Context) ([]*Stack, error)
 	ListNodeGroupStacksWithStatuses(ctx context.Context) ([]NodeGroupStack, error)
 	ListStacks(ctx context.Context) ([]*Stack, error)
 	ListStacksWithStatuses(ctx context.Context, statusFilters ...cfntypes.StackStatus) ([]*Stack, error)
 	ListStacksMatching(ctx context.Context, nameRegex string, statusFilters ...cfntypes.StackStatus) ([]*Stack, error)
+	ListUnownedNodeGroups(ctx context.Context) ([]UnownedNodeGroup, error)
 	LookupCloudTrailEvents(ctx context.Context, i *Stack) ([]cttypes.Event, error)
 	MakeChangeSetName(action string) string
 	MakeClusterStackName() string
 	NewManagedNodeGroupTask(ctx context.Context, nodeGroups []*v1alpha5.ManagedNodeGroup, forceAddCNIPolicy bool, importer vpc.Importer) *tasks.TaskTree
 	NewTaskToDeleteAddonIAM(ctx context.Context, wait bool) (*tasks.TaskTree, error)
 	NewTaskToDeleteUnownedNodeGroup(ctx context.Context, clusterName, nodegroup string, eksAPI awsapi.EKS, waitCondition *DeleteWaitCondition) tasks.Task
+	NewTaskToReconcileOrphanedStacks(ctx context.Context) (*tasks.TaskTree, error)
+	NewTaskToRemediateDrift(ctx context.Context, s *Stack, opts RemediateOptions) (*tasks.TaskTree, *DriftResult, error)
+	NewTasksToAdoptNodeGroups(ctx context.Context, groups []UnownedNodeGroup) (*tasks.TaskTree, []*AdoptionReport, error)
+	NewTasksToRollUnmanagedNodeGroup(ctx context.Context, s *Stack, clientSetGetter kubernetes.ClientSetGetter, options RollNodeGroupOptions) (*tasks.TaskTree, error)
 	NewTasksToCreateClusterWithNodeGroups(ctx context.Context, nodeGroups []*v1alpha5.NodeGroup, managedNodeGroups []*v1alpha5.ManagedNodeGroup, postClusterCreationTasks ...tasks.Task) *tasks.TaskTree
 	NewTasksToCreateIAMServiceAccounts(serviceAccounts []*v1alpha5.ClusterIAMServiceAccount, oidc *iamoidc.OpenIDConnectManager, clientSetGetter kubernetes.ClientSetGetter) *tasks.TaskTree
 	NewTasksToDeleteClusterWithNodeGroups(ctx context.Context, clusterStack *Stack, nodeGroupStacks []NodeGroupStack, clusterOperable bool, newOIDCManager NewOIDCManager, cluster *ekstypes.Cluster, clientSetGetter kubernetes.ClientSetGetter, wait, force bool, cleanup func(chan error, string) error) (*tasks.TaskTree, error)
@@ -0,0 +1,600 @@
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kubeclient "k8s.io/client-go/kubernetes"
+
+	"github.com/weaveworks/eksctl/pkg/kubernetes"
+	"github.com/weaveworks/eksctl/pkg/utils/tasks"
+)
+
+// CloudInstance represents a single ASG member backing an unmanaged nodegroup
+// and whether it is running the nodegroup stack's current launch template.
+type CloudInstance struct {
+	// InstanceID is the EC2 instance ID.
+	InstanceID string
+	// NodeName is the corresponding Kubernetes node name, resolved via the
+	// cluster's clientset. It is empty when the instance hasn't joined yet.
+	NodeName string
+	// LaunchTemplateVersion is the launch template version the instance is
+	// currently running.
+	LaunchTemplateVersion string
+	// AMIID is the AMI the instance was launched from.
+	AMIID string
+	// Ready is true when the instance matches the stack's current launch
+	// template version, AMI and user-data hash.
+	Ready bool
+	// NeedUpdate is the inverse of Ready, kept alongside it so callers can
+	// filter without re-deriving the condition.
+	NeedUpdate bool
+}
+
+// NodeGroupInstances is the set of ASG members backing an unmanaged
+// nodegroup stack, partitioned into those that are up to date and those
+// that still need a rolling update.
+type NodeGroupInstances struct {
+	// NodeGroupName is the InstanceGroup name (the nodegroup name).
+	NodeGroupName string
+	// StackARN is the ARN of the CloudFormation stack that owns the ASG.
+	StackARN string
+	// MinSize, MaxSize and DesiredCapacity mirror the ASG's current sizing.
+	MinSize         int
+	MaxSize         int
+	DesiredCapacity int
+	// Ready holds instances already running the stack's current launch
+	// template, AMI and user-data.
+	Ready []CloudInstance
+	// NeedUpdate holds instances that differ from the stack's current
+	// launch template, AMI, or user-data and must be rolled.
+	NeedUpdate []CloudInstance
+}
+
+// RollNodeGroupOptions controls how NewTasksToRollUnmanagedNodeGroup batches
+// the termination of outdated instances.
+type RollNodeGroupOptions struct {
+	// MaxUnavailable bounds how many NeedUpdate instances go into one
+	// batch. Defaults to 1 when nil. Batches are only held apart when Wait
+	// is set; without it each batch starts as soon as the previous one has
+	// issued its terminations.
+	MaxUnavailable *intstr.IntOrString
+	// Wait makes each batch wait until every ASG member is InService
+	// before the next batch starts.
+	Wait bool
+}
+
+// launchTemplateTarget is the launch template state the stack currently
+// expects every ASG member to be running, resolved from the CFN template
+// body plus a lookup against EC2 for any version still expressed as
+// "$Latest"/"$Default".
+type launchTemplateTarget struct {
+	id           string
+	version      string
+	amiID        string
+	userDataHash string
+}
+
+// DescribeNodeGroupInstances reads the launch template rendered into s's
+// CloudFormation template, then describes the ASG and its instances to
+// classify each member as Ready or NeedUpdate.
+func (c *StackCollection) DescribeNodeGroupInstances(ctx context.Context, s *Stack, clientSetGetter kubernetes.ClientSetGetter) (*NodeGroupInstances, error) {
+	asgName, err := c.GetUnmanagedNodeGroupAutoScalingGroupName(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("getting autoscaling group name for stack %q: %w", *s.StackName, err)
+	}
+
+	target, err := c.currentLaunchTemplateState(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("determining current launch template state for stack %q: %w", *s.StackName, err)
+	}
+
+	asg, err := c.describeAutoScalingGroup(ctx, asgName)
+	if err != nil {
+		return nil, fmt.Errorf("describing autoscaling group %q: %w", asgName, err)
+	}
+
+	instances, err := c.describeCloudInstances(ctx, asg.Instances, target, clientSetGetter)
+	if err != nil {
+		return nil, fmt.Errorf("describing instances for autoscaling group %q: %w", asgName, err)
+	}
+
+	nodeGroupInstances := &NodeGroupInstances{
+		NodeGroupName:   c.GetNodeGroupName(s),
+		StackARN:        *s.StackId,
+		MinSize:         int(*asg.MinSize),
+		MaxSize:         int(*asg.MaxSize),
+		DesiredCapacity: int(*asg.DesiredCapacity),
+	}
+	for _, instance := range instances {
+		if instance.Ready {
+			nodeGroupInstances.Ready = append(nodeGroupInstances.Ready, instance)
+		} else {
+			nodeGroupInstances.NeedUpdate = append(nodeGroupInstances.NeedUpdate, instance)
+		}
+	}
+	return nodeGroupInstances, nil
+}
+
+// ListNodeGroupInstancesNeedingUpdate is a convenience wrapper around
+// DescribeNodeGroupInstances for callers that only care about the instances
+// that still need to be rolled.
+func (c *StackCollection) ListNodeGroupInstancesNeedingUpdate(ctx context.Context, s *Stack, clientSetGetter kubernetes.ClientSetGetter) ([]CloudInstance, error) {
+	nodeGroupInstances, err := c.DescribeNodeGroupInstances(ctx, s, clientSetGetter)
+	if err != nil {
+		return nil, err
+	}
+	return nodeGroupInstances.NeedUpdate, nil
+}
+
+// NewTasksToRollUnmanagedNodeGroup builds a task tree that drains and
+// terminates only the ASG members flagged as NeedUpdate, in batches of at
+// most options.MaxUnavailable instances, instead of rolling the whole CFN
+// stack.
+func (c *StackCollection) NewTasksToRollUnmanagedNodeGroup(ctx context.Context, s *Stack, clientSetGetter kubernetes.ClientSetGetter, options RollNodeGroupOptions) (*tasks.TaskTree, error) {
+	nodeGroupInstances, err := c.DescribeNodeGroupInstances(ctx, s, clientSetGetter)
+	if err != nil {
+		return nil, err
+	}
+
+	taskTree := &tasks.TaskTree{Parallel: false}
+	if len(nodeGroupInstances.NeedUpdate) == 0 {
+		return taskTree, nil
+	}
+
+	batchSize, err := maxUnavailableBatchSize(options.MaxUnavailable, len(nodeGroupInstances.NeedUpdate))
+	if err != nil {
+		return nil, err
+	}
+
+	asgName, err := c.GetUnmanagedNodeGroupAutoScalingGroupName(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("getting autoscaling group name for stack %q: %w", *s.StackName, err)
+	}
+
+	for start := 0; start < len(nodeGroupInstances.NeedUpdate); start += batchSize {
+		end := start + batchSize
+		if end > len(nodeGroupInstances.NeedUpdate) {
+			end = len(nodeGroupInstances.NeedUpdate)
+		}
+		taskTree.Append(&rollNodeGroupBatchTask{
+			info:            fmt.Sprintf("roll %d instance(s) in nodegroup %q", end-start, nodeGroupInstances.NodeGroupName),
+			ctx:             ctx,
+			stackManager:    c,
+			asgName:         asgName,
+			clientSetGetter: clientSetGetter,
+			batch:           nodeGroupInstances.NeedUpdate[start:end],
+			waitForHealth:   options.Wait,
+		})
+	}
+	return taskTree, nil
+}
+
+func maxUnavailableBatchSize(maxUnavailable *intstr.IntOrString, total int) (int, error) {
+	if maxUnavailable == nil {
+		return 1, nil
+	}
+	value, err := intstr.GetScaledValueFromIntOrPercent(maxUnavailable, total, true)
+	if err != nil {
+		return 0, fmt.Errorf("invalid maxUnavailable: %w", err)
+	}
+	if value < 1 {
+		value = 1
+	}
+	return value, nil
+}
+
+// currentLaunchTemplateState reads the launch template name/version rendered
+// into the stack's CFN template, then resolves it against EC2 so a
+// "$Latest"/"$Default" version becomes the concrete number ASG members are
+// actually compared against.
+func (c *StackCollection) currentLaunchTemplateState(ctx context.Context, s *Stack) (*launchTemplateTarget, error) {
+	template, err := c.GetStackTemplate(ctx, *s.StackName)
+	if err != nil {
+		return nil, err
+	}
+	ltName, version, amiID, userDataHash, err := parseLaunchTemplateFromTemplateBody(template)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := c.ec2API.DescribeLaunchTemplates(ctx, &ec2.DescribeLaunchTemplatesInput{
+		LaunchTemplateNames: []string{ltName},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describing launch template %q: %w", ltName, err)
+	}
+	if len(out.LaunchTemplates) == 0 {
+		return nil, fmt.Errorf("launch template %q not found", ltName)
+	}
+	lt := out.LaunchTemplates[0]
+
+	resolvedVersion := version
+	switch version {
+	case "", "$Latest":
+		resolvedVersion = fmt.Sprintf("%d", *lt.LatestVersionNumber)
+	case "$Default":
+		resolvedVersion = fmt.Sprintf("%d", *lt.DefaultVersionNumber)
+	}
+
+	return &launchTemplateTarget{
+		id:           *lt.LaunchTemplateId,
+		version:      resolvedVersion,
+		amiID:        amiID,
+		userDataHash: userDataHash,
+	}, nil
+}
+
+func (c *StackCollection) describeAutoScalingGroup(ctx context.Context, name string) (*asgtypes.AutoScalingGroup, error) {
+	asg, err := c.GetAutoScalingGroupDesiredCapacity(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &asg, nil
+}
+
+// describeCloudInstances describes each ASG member via EC2 and classifies
+// it against the stack's current launch template version, AMI and
+// user-data hash.
+func (c *StackCollection) describeCloudInstances(ctx context.Context, members []asgtypes.Instance, target *launchTemplateTarget, clientSetGetter kubernetes.ClientSetGetter) ([]CloudInstance, error) {
+	instances := make([]CloudInstance, 0, len(members))
+	for _, member := range members {
+		instanceID := *member.InstanceId
+		detail, err := c.describeInstance(ctx, instanceID)
+		if err != nil {
+			return nil, fmt.Errorf("describing instance %q: %w", instanceID, err)
+		}
+		if member.LaunchTemplate != nil && member.LaunchTemplate.Version != nil {
+			detail.launchTemplateVersion = *member.LaunchTemplate.Version
+		}
+
+		nodeName, err := nodeNameForInstance(ctx, clientSetGetter, instanceID)
+		if err != nil {
+			return nil, err
+		}
+
+		ready := classifyInstance(detail, target)
+		instances = append(instances, CloudInstance{
+			InstanceID:            instanceID,
+			NodeName:              nodeName,
+			LaunchTemplateVersion: detail.launchTemplateVersion,
+			AMIID:                 detail.amiID,
+			Ready:                 ready,
+			NeedUpdate:            !ready,
+		})
+	}
+	return instances, nil
+}
+
+// instanceDetail is the live state of a single EC2 instance relevant to
+// classifying it against a launchTemplateTarget.
+type instanceDetail struct {
+	launchTemplateVersion string
+	amiID                 string
+	userDataHash          string
+}
+
+// classifyInstance reports whether detail matches target on launch
+// template version, AMI ID and user-data hash. It is a pure function so it
+// can be unit tested without talking to AWS.
+func classifyInstance(detail *instanceDetail, target *launchTemplateTarget) bool {
+	return detail.launchTemplateVersion == target.version &&
+		detail.amiID == target.amiID &&
+		detail.userDataHash == target.userDataHash
+}
+
+// describeInstance calls EC2 DescribeInstances and DescribeInstanceAttribute
+// for instanceID and extracts the fields needed to classify it. EC2 only
+// accepts a single instance ID per DescribeInstanceAttribute call, so that
+// part is necessarily a per-instance round trip.
+func (c *StackCollection) describeInstance(ctx context.Context, instanceID string) (*instanceDetail, error) {
+	describeOut, err := c.ec2API.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describing EC2 instance %q: %w", instanceID, err)
+	}
+	if len(describeOut.Reservations) == 0 || len(describeOut.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("EC2 instance %q not found", instanceID)
+	}
+	instance := describeOut.Reservations[0].Instances[0]
+
+	attrOut, err := c.ec2API.DescribeInstanceAttribute(ctx, &ec2.DescribeInstanceAttributeInput{
+		InstanceId: &instanceID,
+		Attribute:  ec2types.InstanceAttributeNameUserData,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describing user-data for EC2 instance %q: %w", instanceID, err)
+	}
+
+	var userData string
+	if attrOut.UserData != nil && attrOut.UserData.Value != nil {
+		userData = *attrOut.UserData.Value
+	}
+
+	return &instanceDetail{
+		amiID:        aws.ToString(instance.ImageId),
+		userDataHash: hashUserData(userData),
+	}, nil
+}
+
+// hashUserData decodes base64-encoded user-data (as returned by both the
+// EC2 API and a rendered launch template) and returns its sha256 hex
+// digest, so differently-encoded-but-identical payloads still compare
+// equal.
+func hashUserData(base64Encoded string) string {
+	if base64Encoded == "" {
+		return ""
+	}
+	decoded, err := base64.StdEncoding.DecodeString(base64Encoded)
+	if err != nil {
+		// Not valid base64: hash the raw bytes so a malformed payload still
+		// reliably compares unequal to a well-formed one, instead of
+		// erroring out of classification entirely.
+		decoded = []byte(base64Encoded)
+	}
+	sum := sha256.Sum256(decoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// nodeNameForInstance resolves instanceID to the Kubernetes node name that
+// registered it, by matching Node.Spec.ProviderID. It returns an empty
+// string if the instance hasn't joined the cluster yet.
+func nodeNameForInstance(ctx context.Context, clientSetGetter kubernetes.ClientSetGetter, instanceID string) (string, error) {
+	clientSet, err := clientSetGetter.ClientSet()
+	if err != nil {
+		return "", fmt.Errorf("getting clientset to resolve node name for instance %q: %w", instanceID, err)
+	}
+	nodes, err := clientSet.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("listing nodes to resolve node name for instance %q: %w", instanceID, err)
+	}
+	for _, node := range nodes.Items {
+		if providerIDInstanceID(node.Spec.ProviderID) == instanceID {
+			return node.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// providerIDInstanceID extracts the trailing instance ID from a Kubernetes
+// node's ProviderID, e.g. "aws:///us-west-2a/i-0123456789abcdef0".
+func providerIDInstanceID(providerID string) string {
+	for i := len(providerID) - 1; i >= 0; i-- {
+		if providerID[i] == '/' {
+			return providerID[i+1:]
+		}
+	}
+	return providerID
+}
+
+// cfnTemplate is the minimal shape of a rendered CFN template needed to
+// locate the nodegroup's launch template and its current version.
+type cfnTemplate struct {
+	Resources map[string]struct {
+		Type       string          `json:"Type"`
+		Properties json.RawMessage `json:"Properties"`
+	} `json:"Resources"`
+}
+
+type launchTemplateProperties struct {
+	LaunchTemplateName string `json:"LaunchTemplateName"`
+	LaunchTemplateData struct {
+		ImageId  string `json:"ImageId"`
+		UserData string `json:"UserData"`
+	} `json:"LaunchTemplateData"`
+}
+
+type autoScalingGroupProperties struct {
+	LaunchTemplate struct {
+		Version string `json:"Version"`
+	} `json:"LaunchTemplate"`
+}
+
+// parseLaunchTemplateFromTemplateBody extracts the launch template name,
+// version, AMI ID and user-data hash rendered into an unmanaged
+// nodegroup's CFN template. The version is returned verbatim and may still
+// be "$Latest"/"$Default"; resolving it to a concrete number is the
+// caller's job since that requires an EC2 call.
+func parseLaunchTemplateFromTemplateBody(templateBody string) (ltName, version, amiID, userDataHash string, err error) {
+	var tmpl cfnTemplate
+	if err := json.Unmarshal([]byte(templateBody), &tmpl); err != nil {
+		return "", "", "", "", fmt.Errorf("parsing CFN template: %w", err)
+	}
+
+	var ltProps *launchTemplateProperties
+	var asgProps *autoScalingGroupProperties
+	for _, resource := range tmpl.Resources {
+		switch resource.Type {
+		case "AWS::EC2::LaunchTemplate":
+			var props launchTemplateProperties
+			if err := json.Unmarshal(resource.Properties, &props); err != nil {
+				return "", "", "", "", fmt.Errorf("parsing launch template resource: %w", err)
+			}
+			ltProps = &props
+		case "AWS::AutoScaling::AutoScalingGroup":
+			var props autoScalingGroupProperties
+			if err := json.Unmarshal(resource.Properties, &props); err != nil {
+				return "", "", "", "", fmt.Errorf("parsing autoscaling group resource: %w", err)
+			}
+			asgProps = &props
+		}
+	}
+	if ltProps == nil {
+		return "", "", "", "", fmt.Errorf("no AWS::EC2::LaunchTemplate resource found in template")
+	}
+
+	version = "$Latest"
+	if asgProps != nil && asgProps.LaunchTemplate.Version != "" {
+		version = asgProps.LaunchTemplate.Version
+	}
+
+	return ltProps.LaunchTemplateName, version, ltProps.LaunchTemplateData.ImageId, hashUserData(ltProps.LaunchTemplateData.UserData), nil
+}
+
+// rollNodeGroupBatchTask drains and terminates one batch of outdated
+// instances, then (optionally) waits for the ASG to bring their
+// replacements to a healthy state before the next batch is started.
+type rollNodeGroupBatchTask struct {
+	info            string
+	ctx             context.Context
+	stackManager    *StackCollection
+	asgName         string
+	clientSetGetter kubernetes.ClientSetGetter
+	batch           []CloudInstance
+	waitForHealth   bool
+}
+
+func (t *rollNodeGroupBatchTask) Describe() string { return t.info }
+
+func (t *rollNodeGroupBatchTask) Do(errorCh chan error) error {
+	defer close(errorCh)
+	for _, instance := range t.batch {
+		if err := t.stackManager.drainAndTerminate(t.ctx, t.clientSetGetter, instance.InstanceID, instance.NodeName); err != nil {
+			return fmt.Errorf("rolling instance %q in %q: %w", instance.InstanceID, t.asgName, err)
+		}
+	}
+	if t.waitForHealth {
+		return t.stackManager.waitForASGHealthy(t.ctx, t.asgName)
+	}
+	return nil
+}
+
+// drainAndTerminate cordons and drains nodeName (if it has joined the
+// cluster), then terminates instanceID with ShouldDecrementDesiredCapacity
+// set to false so the ASG launches a replacement from the current launch
+// template.
+func (c *StackCollection) drainAndTerminate(ctx context.Context, clientSetGetter kubernetes.ClientSetGetter, instanceID, nodeName string) error {
+	if nodeName != "" {
+		clientSet, err := clientSetGetter.ClientSet()
+		if err != nil {
+			return fmt.Errorf("getting clientset to drain node %q: %w", nodeName, err)
+		}
+		if err := cordonNode(ctx, clientSet, nodeName); err != nil {
+			return fmt.Errorf("cordoning node %q: %w", nodeName, err)
+		}
+		if err := evictPodsOnNode(ctx, clientSet, nodeName); err != nil {
+			return fmt.Errorf("draining node %q: %w", nodeName, err)
+		}
+	}
+
+	if _, err := c.asgAPI.TerminateInstanceInAutoScalingGroup(ctx, &autoscaling.TerminateInstanceInAutoScalingGroupInput{
+		InstanceId:                     &instanceID,
+		ShouldDecrementDesiredCapacity: aws.Bool(false),
+	}); err != nil {
+		return fmt.Errorf("terminating instance %q: %w", instanceID, err)
+	}
+	return nil
+}
+
+// cordonNode marks nodeName unschedulable so the scheduler stops placing
+// new pods on it ahead of eviction.
+func cordonNode(ctx context.Context, clientSet kubeclient.Interface, nodeName string) error {
+	node, err := clientSet.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if node.Spec.Unschedulable {
+		return nil
+	}
+	node.Spec.Unschedulable = true
+	_, err = clientSet.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+	return err
+}
+
+// evictPodsOnNode evicts every non-DaemonSet pod scheduled on nodeName via
+// the eviction subresource, which honours PodDisruptionBudgets.
+func evictPodsOnNode(ctx context.Context, clientSet kubeclient.Interface, nodeName string) error {
+	pods, err := clientSet.CoreV1().Pods(corev1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + nodeName,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, pod := range pods.Items {
+		if isDaemonSetPod(pod) {
+			continue
+		}
+		eviction := &policyv1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		}
+		if err := clientSet.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("evicting pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+	}
+	return nil
+}
+
+func isDaemonSetPod(pod corev1.Pod) bool {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "DaemonSet" {
+			return true
+		}
+	}
+	return false
+}
+
+// asgHealthPollInterval is how often waitForASGHealthy re-describes the ASG
+// while waiting for its instances to settle.
+const asgHealthPollInterval = 15 * time.Second
+
+// asgHealthTimeout bounds how long waitForASGHealthy waits for every member
+// of an ASG to reach InService after a batch of instances is terminated.
+const asgHealthTimeout = 15 * time.Minute
+
+// waitForASGHealthy polls the ASG until every member reports a healthy
+// lifecycle state, so the next batch doesn't start rolling before the
+// replacements for this batch are up. It's called right after a batch's
+// instances are terminated, so the terminated instances (or their
+// not-yet-launched replacements) are expected to still be transitioning on
+// the first poll.
+func (c *StackCollection) waitForASGHealthy(ctx context.Context, asgName string) error {
+	deadline := time.Now().Add(asgHealthTimeout)
+	for {
+		asg, err := c.describeAutoScalingGroup(ctx, asgName)
+		if err != nil {
+			return err
+		}
+		if allInstancesInService(asg.Instances) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for autoscaling group %q to become healthy", asgName)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(asgHealthPollInterval):
+		}
+	}
+}
+
+// allInstancesInService reports whether every instance in an ASG has
+// reached the InService lifecycle state. It is a pure function so the
+// health check used by waitForASGHealthy's poll loop can be unit tested
+// without talking to AWS.
+func allInstancesInService(instances []asgtypes.Instance) bool {
+	for _, instance := range instances {
+		if instance.LifecycleState != asgtypes.LifecycleStateInService {
+			return false
+		}
+	}
+	return true
+}
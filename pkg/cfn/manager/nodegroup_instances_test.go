@@ -0,0 +1,229 @@
+package manager
+
+import (
+	"encoding/base64"
+	"testing"
+
+	asgtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestClassifyInstance(t *testing.T) {
+	target := &launchTemplateTarget{
+		id:           "lt-1234",
+		version:      "3",
+		amiID:        "ami-current",
+		userDataHash: hashUserData(base64.StdEncoding.EncodeToString([]byte("current-user-data"))),
+	}
+
+	tests := []struct {
+		name   string
+		detail *instanceDetail
+		want   bool
+	}{
+		{
+			name: "matches version, AMI and user-data",
+			detail: &instanceDetail{
+				launchTemplateVersion: "3",
+				amiID:                 "ami-current",
+				userDataHash:          target.userDataHash,
+			},
+			want: true,
+		},
+		{
+			name: "stale launch template version",
+			detail: &instanceDetail{
+				launchTemplateVersion: "2",
+				amiID:                 "ami-current",
+				userDataHash:          target.userDataHash,
+			},
+			want: false,
+		},
+		{
+			name: "stale AMI despite matching version",
+			detail: &instanceDetail{
+				launchTemplateVersion: "3",
+				amiID:                 "ami-old",
+				userDataHash:          target.userDataHash,
+			},
+			want: false,
+		},
+		{
+			name: "diverged user-data despite matching version and AMI",
+			detail: &instanceDetail{
+				launchTemplateVersion: "3",
+				amiID:                 "ami-current",
+				userDataHash:          hashUserData(base64.StdEncoding.EncodeToString([]byte("other-user-data"))),
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyInstance(tt.detail, target); got != tt.want {
+				t.Errorf("classifyInstance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLaunchTemplateFromTemplateBody(t *testing.T) {
+	userData := base64.StdEncoding.EncodeToString([]byte("#!/bin/bash\nrun-bootstrap"))
+	template := `{
+		"Resources": {
+			"LaunchTemplate": {
+				"Type": "AWS::EC2::LaunchTemplate",
+				"Properties": {
+					"LaunchTemplateName": "eksctl-ng-1-nodegroup",
+					"LaunchTemplateData": {
+						"ImageId": "ami-0123456789abcdef0",
+						"UserData": "` + userData + `"
+					}
+				}
+			},
+			"NodeGroup": {
+				"Type": "AWS::AutoScaling::AutoScalingGroup",
+				"Properties": {
+					"LaunchTemplate": {
+						"Version": "5"
+					}
+				}
+			}
+		}
+	}`
+
+	ltName, version, amiID, userDataHash, err := parseLaunchTemplateFromTemplateBody(template)
+	if err != nil {
+		t.Fatalf("parseLaunchTemplateFromTemplateBody() error = %v", err)
+	}
+	if ltName != "eksctl-ng-1-nodegroup" {
+		t.Errorf("ltName = %q, want %q", ltName, "eksctl-ng-1-nodegroup")
+	}
+	if version != "5" {
+		t.Errorf("version = %q, want %q", version, "5")
+	}
+	if amiID != "ami-0123456789abcdef0" {
+		t.Errorf("amiID = %q, want %q", amiID, "ami-0123456789abcdef0")
+	}
+	if userDataHash != hashUserData(userData) {
+		t.Errorf("userDataHash = %q, want %q", userDataHash, hashUserData(userData))
+	}
+}
+
+func TestParseLaunchTemplateFromTemplateBodyDefaultsVersionToLatest(t *testing.T) {
+	template := `{
+		"Resources": {
+			"LaunchTemplate": {
+				"Type": "AWS::EC2::LaunchTemplate",
+				"Properties": {
+					"LaunchTemplateName": "eksctl-ng-1-nodegroup",
+					"LaunchTemplateData": {"ImageId": "ami-0123456789abcdef0"}
+				}
+			}
+		}
+	}`
+
+	_, version, _, _, err := parseLaunchTemplateFromTemplateBody(template)
+	if err != nil {
+		t.Fatalf("parseLaunchTemplateFromTemplateBody() error = %v", err)
+	}
+	if version != "$Latest" {
+		t.Errorf("version = %q, want %q", version, "$Latest")
+	}
+}
+
+func TestParseLaunchTemplateFromTemplateBodyMissingLaunchTemplate(t *testing.T) {
+	if _, _, _, _, err := parseLaunchTemplateFromTemplateBody(`{"Resources": {}}`); err == nil {
+		t.Fatal("expected an error when no AWS::EC2::LaunchTemplate resource is present")
+	}
+}
+
+func TestHashUserData(t *testing.T) {
+	if hashUserData("") != "" {
+		t.Errorf("hashUserData(\"\") = %q, want empty string", hashUserData(""))
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte("same-data"))
+	if hashUserData(encoded) != hashUserData(encoded) {
+		t.Error("hashUserData() is not deterministic for identical input")
+	}
+
+	other := base64.StdEncoding.EncodeToString([]byte("different-data"))
+	if hashUserData(encoded) == hashUserData(other) {
+		t.Error("hashUserData() produced the same hash for different user-data")
+	}
+}
+
+func TestProviderIDInstanceID(t *testing.T) {
+	tests := map[string]string{
+		"aws:///us-west-2a/i-0123456789abcdef0": "i-0123456789abcdef0",
+		"i-0123456789abcdef0":                   "i-0123456789abcdef0",
+		"":                                      "",
+	}
+	for providerID, want := range tests {
+		if got := providerIDInstanceID(providerID); got != want {
+			t.Errorf("providerIDInstanceID(%q) = %q, want %q", providerID, got, want)
+		}
+	}
+}
+
+func TestMaxUnavailableBatchSize(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxUnavailable *intstr.IntOrString
+		total          int
+		want           int
+	}{
+		{name: "nil defaults to 1", maxUnavailable: nil, total: 10, want: 1},
+		{name: "integer value", maxUnavailable: intOrStringPtr(intstr.FromInt(3)), total: 10, want: 3},
+		{name: "percentage value", maxUnavailable: intOrStringPtr(intstr.FromString("50%")), total: 10, want: 5},
+		{name: "rounds up to at least 1", maxUnavailable: intOrStringPtr(intstr.FromString("1%")), total: 10, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := maxUnavailableBatchSize(tt.maxUnavailable, tt.total)
+			if err != nil {
+				t.Fatalf("maxUnavailableBatchSize() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("maxUnavailableBatchSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func intOrStringPtr(v intstr.IntOrString) *intstr.IntOrString { return &v }
+
+func TestAllInstancesInService(t *testing.T) {
+	tests := []struct {
+		name      string
+		instances []asgtypes.Instance
+		want      bool
+	}{
+		{name: "no instances", instances: nil, want: true},
+		{
+			name:      "all in service",
+			instances: []asgtypes.Instance{{LifecycleState: asgtypes.LifecycleStateInService}, {LifecycleState: asgtypes.LifecycleStateInService}},
+			want:      true,
+		},
+		{
+			name:      "one instance still pending",
+			instances: []asgtypes.Instance{{LifecycleState: asgtypes.LifecycleStateInService}, {LifecycleState: asgtypes.LifecycleStatePending}},
+			want:      false,
+		},
+		{
+			name:      "a terminating replacement",
+			instances: []asgtypes.Instance{{LifecycleState: asgtypes.LifecycleStateTerminating}},
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allInstancesInService(tt.instances); got != tt.want {
+				t.Errorf("allInstancesInService() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}